@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// arpSweepWait is how long to keep listening for replies after the last request
+var arpSweepWait = 2 * time.Second
+
+const (
+	etherTypeARP  = 0x0806
+	etherTypeIPv4 = 0x0800
+	arpRequest    = 1
+	arpReply      = 2
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// subnetHosts lists the host addresses of a subnet except our own. Subnets
+// with more than maxHosts addresses are refused so a /8 on a VPN adapter
+// doesn't turn into millions of packets.
+func subnetHosts(ipnet *net.IPNet, maxHosts int) ([]netip.Addr, error) {
+	self, ok := netip.AddrFromSlice(ipnet.IP.To4())
+	if !ok {
+		return nil, fmt.Errorf("%s is not an IPv4 subnet", ipnet)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits != 32 {
+		return nil, fmt.Errorf("%s is not an IPv4 subnet", ipnet)
+	}
+	prefix := netip.PrefixFrom(self, ones).Masked()
+	size := 1 << (32 - ones)
+	// the network and broadcast addresses aren't hosts, except in /31s
+	if ones < 31 {
+		size -= 2
+	}
+	if size > maxHosts {
+		return nil, fmt.Errorf("subnet %s has %d hosts, more than the limit of %d", prefix, size, maxHosts)
+	}
+	network := prefix.Addr().As4()
+	// the broadcast address has every host bit set
+	broadcast := binary.BigEndian.Uint32(network[:]) | ^uint32(0)>>ones
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], broadcast)
+	first, last := prefix.Addr(), netip.AddrFrom4(b)
+	if ones < 31 {
+		first = first.Next()
+		last = last.Prev()
+	}
+	hosts := []netip.Addr{}
+	for a := first; a.Compare(last) <= 0 && a.IsValid(); a = a.Next() {
+		if a != self {
+			hosts = append(hosts, a)
+		}
+	}
+	return hosts, nil
+}
+
+// buildARPRequest builds a broadcast ethernet frame asking who has target
+func buildARPRequest(srcMAC net.HardwareAddr, srcIP netip.Addr, target netip.Addr) []byte {
+	var b bytes.Buffer
+	b.Write(broadcastMAC)
+	b.Write(srcMAC)
+	binary.Write(&b, binary.BigEndian, uint16(etherTypeARP))
+	binary.Write(&b, binary.BigEndian, uint16(1)) // ethernet
+	binary.Write(&b, binary.BigEndian, uint16(etherTypeIPv4))
+	b.WriteByte(6)
+	b.WriteByte(4)
+	binary.Write(&b, binary.BigEndian, uint16(arpRequest))
+	src4, dst4 := srcIP.As4(), target.As4()
+	b.Write(srcMAC)
+	b.Write(src4[:])
+	b.Write(make([]byte, 6))
+	b.Write(dst4[:])
+	return b.Bytes()
+}
+
+// parseARPReply returns the sender of an ethernet ARP reply frame
+func parseARPReply(frame []byte) (netip.Addr, net.HardwareAddr, bool) {
+	// 14 byte ethernet header + 28 byte ARP packet
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return netip.Addr{}, nil, false
+	}
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[2:4]) != etherTypeIPv4 || arp[4] != 6 || arp[5] != 4 ||
+		binary.BigEndian.Uint16(arp[6:8]) != arpReply {
+		return netip.Addr{}, nil, false
+	}
+	ip, _ := netip.AddrFromSlice(arp[14:18])
+	mac := net.HardwareAddr(bytes.Clone(arp[8:14]))
+	return ip, mac, true
+}
+
+// mergeNeighbors adds the entries of b that a doesn't have yet and fills in
+// the MAC and state of the ones it does
+func mergeNeighbors(a ARPDiscovery, b ARPDiscovery) ARPDiscovery {
+	for _, n := range b {
+		found := false
+		for i := range a {
+			if a[i].IP == n.IP && a[i].Interface == n.Interface {
+				found = true
+				if n.MAC != "" {
+					a[i].MAC = n.MAC
+					a[i].State = n.State
+					a[i].EUI64 = n.EUI64
+				}
+				break
+			}
+		}
+		if !found {
+			a = append(a, n)
+		}
+	}
+	return a
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// htons for the AF_PACKET protocol field
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arpSweep sends an ARP request to every host of every IPv4 subnet the
+// machine is on, at most rate requests per second, and returns the hosts
+// that answered. It needs CAP_NET_RAW.
+func arpSweep(rate int, maxHosts int) (ARPDiscovery, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("arp sweep rate must be positive")
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	neighbors := ARPDiscovery{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Error().Err(err).Str("interface", iface.Name).Msg("Failed to list interface addresses")
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			hosts, err := subnetHosts(ipnet, maxHosts)
+			if err != nil {
+				log.Warn().Err(err).Str("interface", iface.Name).Msg("Skipping subnet in ARP sweep")
+				continue
+			}
+			self, _ := netip.AddrFromSlice(ipnet.IP.To4())
+			found, err := sweepInterface(iface, self, hosts, rate)
+			if err != nil {
+				log.Error().Err(err).Str("interface", iface.Name).Msg("ARP sweep of interface failed")
+				continue
+			}
+			neighbors = append(neighbors, found...)
+		}
+	}
+	return neighbors, nil
+}
+
+// sweepInterface does the sweep of one subnet on a raw AF_PACKET socket
+// bound to the interface
+func sweepInterface(iface net.Interface, self netip.Addr, hosts []netip.Addr, rate int) (ARPDiscovery, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	defer unix.Close(fd)
+	addr := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}
+	if err := unix.Bind(fd, addr); err != nil {
+		return nil, fmt.Errorf("failed to bind packet socket to %s: %w", iface.Name, err)
+	}
+	// short read timeout so the receiver notices when we're done
+	tv := unix.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return nil, err
+	}
+
+	targets := map[netip.Addr]bool{}
+	for _, h := range hosts {
+		targets[h] = true
+	}
+	replies := map[netip.Addr]net.HardwareAddr{}
+	var mu sync.Mutex
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == unix.EAGAIN || err == unix.EINTR {
+					continue
+				}
+				log.Error().Err(err).Str("interface", iface.Name).Msg("Failed to read ARP replies")
+				return
+			}
+			ip, mac, ok := parseARPReply(buf[:n])
+			if !ok || !targets[ip] {
+				continue
+			}
+			mu.Lock()
+			replies[ip] = mac
+			mu.Unlock()
+		}
+	}()
+
+	log.Info().
+		Str("interface", iface.Name).
+		Str("source", self.String()).
+		Int("hosts", len(hosts)).
+		Int("rate", rate).
+		Msg("Starting ARP sweep")
+	dst := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(dst.Addr[:], broadcastMAC)
+	interval := time.Second / time.Duration(rate)
+	for _, h := range hosts {
+		if err := unix.Sendto(fd, buildARPRequest(iface.HardwareAddr, self, h), 0, dst); err != nil {
+			log.Error().Err(err).Str("target", h.String()).Msg("Failed to send ARP request")
+		}
+		time.Sleep(interval)
+	}
+	time.Sleep(arpSweepWait)
+	close(done)
+	wg.Wait()
+
+	neighbors := ARPDiscovery{}
+	for _, h := range hosts {
+		mac, ok := replies[h]
+		if !ok {
+			continue
+		}
+		n := Neighbor{
+			IP:        h.String(),
+			MAC:       normalizeMAC(mac.String()),
+			Interface: iface.Name,
+			State:     "REACHABLE",
+			Family:    "ipv4",
+		}
+		log.Debug().Str("IP", n.IP).Str("MAC", n.MAC).Str("interface", n.Interface).Msg("Discovered neighbor via ARP sweep")
+		neighbors = append(neighbors, n)
+	}
+	return neighbors, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestARPSweepNetns sweeps a veth pair between two network namespaces, the
+// same setup as the README example. It needs root.
+func TestARPSweepNetns(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create network namespaces")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("needs the ip command")
+	}
+	ns := fmt.Sprintf("ipkvmtest%d", os.Getpid())
+	peer := fmt.Sprintf("ipkvmpeer%d", os.Getpid())
+	ip := func(args ...string) error {
+		out, err := exec.Command("ip", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ip %v: %w: %s", args, err, out)
+		}
+		return nil
+	}
+	t.Cleanup(func() {
+		ip("netns", "del", ns)
+		ip("netns", "del", peer)
+	})
+	for _, args := range [][]string{
+		{"netns", "add", ns},
+		{"netns", "add", peer},
+		{"link", "add", "veth0", "netns", ns, "type", "veth", "peer", "name", "veth1", "netns", peer},
+		{"-n", ns, "addr", "add", "10.99.0.1/29", "dev", "veth0"},
+		{"-n", ns, "link", "set", "veth0", "up"},
+		{"-n", peer, "addr", "add", "10.99.0.5/29", "dev", "veth1"},
+		{"-n", peer, "link", "set", "veth1", "address", "94:83:c4:00:00:01", "up"},
+	} {
+		if err := ip(args...); err != nil {
+			t.Skipf("can't set up the namespaces: %v", err)
+		}
+	}
+
+	defer func(wait time.Duration) { arpSweepWait = wait }(arpSweepWait)
+	arpSweepWait = 500 * time.Millisecond
+
+	// the sockets are opened on this thread so only it has to move, the
+	// thread is thrown away if it can't be moved back
+	runtime.LockOSThread()
+	orig, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+	target, err := os.Open("/run/netns/" + ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		t.Fatal(err)
+	}
+	neighbors, err := arpSweep(200, 16)
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err == nil {
+		runtime.UnlockOSThread()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(neighbors) != 1 {
+		t.Fatalf("got neighbors %+v, want only the peer", neighbors)
+	}
+	n := neighbors[0]
+	if n.IP != "10.99.0.5" || n.MAC != "94:83:c4:00:00:01" || n.Interface != "veth0" || n.Family != "ipv4" {
+		t.Errorf("got neighbor %+v", n)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// arpSweep needs AF_PACKET sockets which are linux only
+func arpSweep(rate int, maxHosts int) (ARPDiscovery, error) {
+	return nil, fmt.Errorf("arp sweep is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		count int
+		first string
+		last  string
+	}{
+		{"10.99.0.1/29", 5, "10.99.0.2", "10.99.0.6"},
+		{"192.168.1.77/24", 253, "192.168.1.1", "192.168.1.254"},
+		{"10.0.0.0/31", 1, "10.0.0.1", "10.0.0.1"},
+		{"10.0.0.1/32", 0, "", ""},
+	}
+	for _, tt := range tests {
+		ip, ipnet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipnet.IP = ip
+		hosts, err := subnetHosts(ipnet, 1024)
+		if err != nil {
+			t.Errorf("%s: %v", tt.cidr, err)
+			continue
+		}
+		if len(hosts) != tt.count {
+			t.Errorf("%s: got %d hosts, want %d", tt.cidr, len(hosts), tt.count)
+			continue
+		}
+		if tt.count > 0 && (hosts[0].String() != tt.first || hosts[len(hosts)-1].String() != tt.last) {
+			t.Errorf("%s: got %s - %s, want %s - %s", tt.cidr, hosts[0], hosts[len(hosts)-1], tt.first, tt.last)
+		}
+	}
+}
+
+func TestSubnetHostsRefusesLargeSubnets(t *testing.T) {
+	for _, cidr := range []string{"10.1.2.3/8", "128.0.0.1/1", "1.2.3.4/0"} {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipnet.IP = ip
+		start := time.Now()
+		if _, err := subnetHosts(ipnet, 1024); err == nil {
+			t.Errorf("%s: expected the subnet to be refused", cidr)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("%s: took %s to refuse", cidr, elapsed)
+		}
+	}
+}
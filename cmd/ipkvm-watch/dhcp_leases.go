@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dhcpLease is a lease that names the client it was handed to
+type dhcpLease struct {
+	IP       string
+	MAC      string
+	Hostname string
+}
+
+// parseDHCPLeases reads the leases of a DHCP server, it takes dnsmasq,
+// ISC dhcpd and macOS bootpd lease files.
+//
+// dnsmasq (/var/lib/misc/dnsmasq.leases), expiry mac ip hostname client-id,
+// IPv6 leases come after the duid line with the IAID instead of the mac:
+//
+//	1760650000 94:83:c4:ae:ac:2a 192.168.8.23 glkvm 01:94:83:c4:ae:ac:2a
+//	1760650000 41952234 fd00:8::1b2 glkvm 00:04:4e:a2:...
+//
+// ISC dhcpd (/var/lib/dhcp/dhcpd.leases):
+//
+//	lease 192.168.8.23 {
+//	  hardware ethernet 94:83:c4:ae:ac:2a;
+//	  client-hostname "glkvm";
+//	}
+//
+// macOS bootpd (/var/db/dhcpd_leases):
+//
+//	{
+//		name=glkvm
+//		ip_address=192.168.8.23
+//		hw_address=1,94:83:c4:ae:ac:2a
+//	}
+func parseDHCPLeases(input string) []dhcpLease {
+	leases := []dhcpLease{}
+	var block *dhcpLease
+	done := func() {
+		if block != nil && block.IP != "" && block.Hostname != "" {
+			leases = append(leases, *block)
+		}
+		block = nil
+	}
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "{":
+			block = &dhcpLease{}
+		case line == "}":
+			done()
+		case len(fields) >= 2 && fields[0] == "lease" && line[len(line)-1] == '{':
+			block = &dhcpLease{IP: fields[1]}
+		case block != nil && strings.Contains(line, "=") && !strings.HasSuffix(line, ";"):
+			key, value, _ := strings.Cut(line, "=")
+			switch key {
+			case "name":
+				block.Hostname = value
+			case "ip_address":
+				block.IP = value
+			case "hw_address":
+				// hardware type, then the address
+				_, mac, _ := strings.Cut(value, ",")
+				block.MAC = normalizeMAC(mac)
+			}
+		case block != nil:
+			value := strings.Trim(strings.TrimSuffix(line, ";"), " ")
+			if v, ok := strings.CutPrefix(value, "client-hostname "); ok {
+				block.Hostname = strings.Trim(v, `"`)
+			} else if v, ok := strings.CutPrefix(value, "hardware ethernet "); ok {
+				block.MAC = normalizeMAC(v)
+			}
+		case len(fields) >= 4:
+			if _, err := netip.ParseAddr(fields[2]); err != nil || fields[3] == "*" {
+				continue
+			}
+			lease := dhcpLease{IP: fields[2], Hostname: fields[3]}
+			// IPv6 leases have the IAID where the MAC goes
+			if _, err := net.ParseMAC(fields[1]); err == nil {
+				lease.MAC = normalizeMAC(fields[1])
+			}
+			leases = append(leases, lease)
+		}
+	}
+	return leases
+}
+
+// readDHCPLeases lists the client hostnames of the lease files, for when
+// the tool runs on (or was given the files of) the DHCP server
+func readDHCPLeases(paths []string) []observedName {
+	names := []observedName{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to read DHCP leases")
+			continue
+		}
+		for _, lease := range parseDHCPLeases(string(b)) {
+			addr, err := netip.ParseAddr(lease.IP)
+			if err != nil {
+				continue
+			}
+			log.Debug().Str("ip", lease.IP).Str("mac", lease.MAC).Str("name", lease.Hostname).Msg("DHCP lease")
+			names = append(names, observedName{Name: lease.Hostname, Method: "dhcp", Source: path, Addrs: []netip.Addr{addr}})
+		}
+	}
+	return names
+}
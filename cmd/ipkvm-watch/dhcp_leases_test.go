@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDHCPLeases(t *testing.T) {
+	tests := []struct {
+		path string
+		want []dhcpLease
+	}{
+		{"testdata/leases/dnsmasq.leases", []dhcpLease{
+			{IP: "192.168.8.23", MAC: "94:83:c4:ae:ac:2a", Hostname: "glkvm"},
+			{IP: "192.168.8.31", MAC: "b8:27:eb:5c:10:8e", Hostname: "pikvm"},
+			{IP: "fd00:8::1b2", Hostname: "glkvm"},
+		}},
+		{"testdata/leases/dhcpd.leases", []dhcpLease{
+			{IP: "192.168.8.23", MAC: "94:83:c4:ae:ac:2a", Hostname: "glkvm"},
+			{IP: "192.168.8.31", MAC: "b8:27:eb:5c:10:8e", Hostname: "pikvm"},
+		}},
+		{"testdata/leases/dhcpd_leases", []dhcpLease{
+			{IP: "192.168.2.3", MAC: "b8:27:eb:01:02:03", Hostname: "pikvm"},
+			{IP: "192.168.2.4", MAC: "94:83:c4:ae:ac:2a", Hostname: "glkvm"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := parseDHCPLeases(readFixture(t, tt.path)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestReadDHCPLeases(t *testing.T) {
+	names := readDHCPLeases([]string{"testdata/leases/dnsmasq.leases", "testdata/leases/missing"})
+	if len(names) != 3 {
+		t.Fatalf("got %d names, want 3", len(names))
+	}
+	n := names[2]
+	if n.Name != "glkvm" || n.Method != "dhcp" || n.Source != "testdata/leases/dnsmasq.leases" || len(n.Addrs) != 1 || n.Addrs[0].String() != "fd00:8::1b2" {
+		t.Errorf("got %+v", n)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// drmRoot is where linux exposes display connectors and their EDID.
+// It is a variable so that captured EDID blobs can be used instead.
+var drmRoot = "/sys/class/drm"
+
+// DisplayRecord is a connected display and its decoded EDID
+type DisplayRecord struct {
+	Connector string
+	EDID      EDID
+}
+
+type DisplayFinding struct {
+	Vendor          string
+	Confidence      string
+	Connector       string
+	ManufacturerID  string
+	ProductCode     string
+	Serial          string
+	Name            string
+	ManufactureWeek int
+	ManufactureYear int
+	ModelYear       bool
+	Modes           []string
+	MatchedFields   []string
+	Reference       string
+}
+
+// readDRMDisplays reads <root>/*/edid. Connectors with nothing plugged in
+// have an empty edid file and are skipped.
+func readDRMDisplays(root string) ([]DisplayRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "*", "edid"))
+	if err != nil {
+		return nil, err
+	}
+	displays := []DisplayRecord{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		connector := filepath.Base(filepath.Dir(path))
+		e, err := decodeEDID(b)
+		if err != nil {
+			log.Debug().Err(err).Str("connector", connector).Msg("Failed to decode EDID")
+			continue
+		}
+		log.Debug().
+			Str("connector", connector).
+			Str("manufacturer", e.ManufacturerID).
+			Str("name", e.Name).
+			Msg("Discovered display")
+		displays = append(displays, DisplayRecord{Connector: connector, EDID: e})
+	}
+	return displays, nil
+}
+
+// matchDisplay checks one display against one rule. manufacturer_id with
+// product_code, name and serial each match on their own.
+func matchDisplay(vendor string, indicator DisplayIndicator, display DisplayRecord) (DisplayFinding, bool) {
+	e := display.EDID
+	f := DisplayFinding{
+		Vendor:          vendor,
+		Connector:       display.Connector,
+		ManufacturerID:  e.ManufacturerID,
+		ProductCode:     fmt.Sprintf("0x%04x", e.ProductCode),
+		Serial:          e.SerialString,
+		Name:            e.Name,
+		ManufactureWeek: e.ManufactureWeek,
+		ManufactureYear: e.ManufactureYear,
+		ModelYear:       e.ModelYear,
+		Modes:           e.Modes,
+		MatchedFields:   []string{},
+		Reference:       indicator.Reference,
+	}
+	if f.Serial == "" && e.SerialNumber != 0 {
+		f.Serial = strconv.FormatUint(uint64(e.SerialNumber), 10)
+	}
+	matched := func(confidence string, fields ...string) {
+		f.MatchedFields = append(f.MatchedFields, fields...)
+		if confidenceRank(f.Confidence) < confidenceRank(confidence) {
+			f.Confidence = confidence
+		}
+	}
+	if indicator.ManufacturerID != "" && strings.EqualFold(indicator.ManufacturerID, e.ManufacturerID) {
+		if indicator.ProductCode == "" {
+			matched("low", "manufacturer_id")
+		} else if code, err := strconv.ParseUint(indicator.ProductCode, 0, 16); err == nil && uint16(code) == e.ProductCode {
+			matched("medium", "manufacturer_id", "product_code")
+		}
+	}
+	if indicator.Name != "" && strings.Contains(strings.ToLower(e.Name), strings.ToLower(indicator.Name)) {
+		matched("high", "name")
+	}
+	if indicator.Serial != "" && (strings.EqualFold(indicator.Serial, e.SerialString) || indicator.Serial == strconv.FormatUint(uint64(e.SerialNumber), 10)) {
+		matched("medium", "serial")
+	}
+	if len(f.MatchedFields) == 0 {
+		return f, false
+	}
+	if indicator.Confidence != "" {
+		f.Confidence = indicator.Confidence
+	}
+	return f, true
+}
+
+func checkDisplays(displayIndicators map[string][]DisplayIndicator) []DisplayFinding {
+	findings := []DisplayFinding{}
+	if runtime.GOOS != "linux" {
+		log.Warn().Str("os", runtime.GOOS).Msg("Display discovery not supported on this OS")
+		return findings
+	}
+	displays, err := readDRMDisplays(drmRoot)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read displays")
+		return findings
+	}
+	for _, display := range displays {
+		for vendor, indicators := range displayIndicators {
+			for _, indicator := range indicators {
+				f, ok := matchDisplay(vendor, indicator, display)
+				if !ok {
+					continue
+				}
+				findings = append(findings, f)
+				log.Info().
+					Str("vendor", vendor).
+					Str("confidence", f.Confidence).
+					Str("connector", f.Connector).
+					Str("name", f.Name).
+					Strs("matched", f.MatchedFields).
+					Msg("Matched display EDID")
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"maps"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnssdServiceTypes are always browsed, on top of the types
+// _services._dns-sd._udp enumerates and the ones named in the indicators
+var dnssdServiceTypes = []string{"_http._tcp", "_https._tcp", "_ssh._tcp", "_workstation._tcp"}
+
+const dnssdEnumerationName = "_services._dns-sd._udp.local"
+
+// dnssdMaxRounds bounds the query rounds: types, instances, SRV/TXT and
+// the addresses of the SRV targets
+const dnssdMaxRounds = 4
+
+// DNSSDService is one advertised service instance as heard on one
+// interface and family
+type DNSSDService struct {
+	Instance  string // ex: PiKVM
+	Type      string // ex: _https._tcp
+	Target    string // SRV target host, ex: pikvm.local
+	Port      uint16
+	TXT       []string
+	Addresses []string
+	Interface string
+	Family    string
+	Source    string
+}
+
+type DNSSDFinding struct {
+	Vendor     string
+	Confidence string
+	Type       string // service, instance, txt or target
+	Value      string
+	Service    DNSSDService
+	Reference  string
+}
+
+// dnssdBrowser collects what the responders said over the query rounds
+type dnssdBrowser struct {
+	types     []string // lowercase without .local
+	services  map[string]*DNSSDService
+	order     []string
+	hasSRV    map[string]bool
+	addresses map[string][]string // SRV target -> addresses
+}
+
+// serviceName matches an instance name up to its _x._tcp or _x._udp type,
+// the instance keeps any dots it has
+// ex: "PiKVM v4.local._https._tcp.local."
+var serviceName = regexp.MustCompile(`(?i)^(.+)\.(_[^.]+\._(?:tcp|udp))(?:\.local)?\.?$`)
+
+// serviceType splits "PiKVM._https._tcp.local" into the instance and type
+func serviceType(fullName string) (string, string, bool) {
+	m := serviceName.FindStringSubmatch(fullName)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], strings.ToLower(m[2]), true
+}
+
+// service returns the entry of an instance heard on the packet's interface
+// and family, adding it the first time
+func (b *dnssdBrowser) service(fullName string, pkt mdnsPacket) *DNSSDService {
+	instance, t, ok := serviceType(fullName)
+	if !ok {
+		return nil
+	}
+	key := strings.ToLower(strings.TrimSuffix(fullName, ".")) + "|" + pkt.Interface + "|" + pkt.Family
+	s, ok := b.services[key]
+	if !ok {
+		s = &DNSSDService{
+			Instance:  instance,
+			Type:      t,
+			TXT:       []string{},
+			Addresses: []string{},
+			Interface: pkt.Interface,
+			Family:    pkt.Family,
+			Source:    pkt.Source,
+		}
+		b.services[key] = s
+		b.order = append(b.order, key)
+	}
+	return s
+}
+
+func (b *dnssdBrowser) add(pkt mdnsPacket) {
+	for _, rr := range mdnsResources(pkt.msg) {
+		name := mdnsName(rr.Header.Name)
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == dnssdEnumerationName {
+				t := strings.TrimSuffix(mdnsName(body.PTR), ".local")
+				if !slices.Contains(b.types, t) {
+					log.Debug().Str("type", t).Str("src", pkt.Source).Msg("DNS-SD service type")
+					b.types = append(b.types, t)
+				}
+				continue
+			}
+			b.service(body.PTR.String(), pkt)
+		case *dnsmessage.SRVResource:
+			if s := b.service(rr.Header.Name.String(), pkt); s != nil {
+				s.Target = mdnsName(body.Target)
+				s.Port = body.Port
+				b.hasSRV[name] = true
+			}
+		case *dnsmessage.TXTResource:
+			if s := b.service(rr.Header.Name.String(), pkt); s != nil {
+				for _, txt := range body.TXT {
+					if txt != "" && !slices.Contains(s.TXT, txt) {
+						s.TXT = append(s.TXT, txt)
+					}
+				}
+			}
+		case *dnsmessage.AResource:
+			b.addAddress(name, withLinkZone(netip.AddrFrom4(body.A), pkt.Interface))
+		case *dnsmessage.AAAAResource:
+			b.addAddress(name, withLinkZone(netip.AddrFrom16(body.AAAA), pkt.Interface))
+		}
+	}
+}
+
+func (b *dnssdBrowser) addAddress(host string, addr netip.Addr) {
+	if !slices.Contains(b.addresses[host], addr.String()) {
+		b.addresses[host] = append(b.addresses[host], addr.String())
+	}
+}
+
+// pending lists the questions the next round still has to ask
+func (b *dnssdBrowser) pending(asked map[dnsmessage.Question]bool) []dnsmessage.Question {
+	names := map[dnsmessage.Type][]string{}
+	for _, t := range b.types {
+		names[dnsmessage.TypePTR] = append(names[dnsmessage.TypePTR], t+".local")
+	}
+	for _, key := range b.order {
+		s := b.services[key]
+		fullName, _, _ := strings.Cut(key, "|")
+		// dnsmessage splits names on every dot so an instance label with a
+		// dot in it can't be asked for, its SRV and TXT have to come with
+		// the PTR answer
+		if !b.hasSRV[fullName] && strings.Contains(s.Instance, ".") {
+			log.Debug().Str("instance", s.Instance).Msg("Not asking for the SRV of a dotted instance")
+		} else if !b.hasSRV[fullName] {
+			names[dnsmessage.TypeSRV] = append(names[dnsmessage.TypeSRV], fullName)
+			names[dnsmessage.TypeTXT] = append(names[dnsmessage.TypeTXT], fullName)
+		}
+		if s.Target != "" && len(b.addresses[s.Target]) == 0 {
+			names[dnsmessage.TypeA] = append(names[dnsmessage.TypeA], s.Target)
+			names[dnsmessage.TypeAAAA] = append(names[dnsmessage.TypeAAAA], s.Target)
+		}
+	}
+	questions := []dnsmessage.Question{}
+	for _, t := range slices.Sorted(maps.Keys(names)) {
+		qs, err := mdnsQuestions(names[t], t)
+		if err != nil {
+			log.Debug().Err(err).Msg("Skipping DNS-SD name")
+			continue
+		}
+		for _, q := range qs {
+			if !asked[q] {
+				asked[q] = true
+				questions = append(questions, q)
+			}
+		}
+	}
+	return questions
+}
+
+// browseDNSSD enumerates the advertised service types, browses them and
+// the extra types given, and resolves the SRV, TXT and addresses of every
+// instance. ifaceNames limits the interfaces used.
+func browseDNSSD(extraTypes []string, ifaceNames []string) ([]DNSSDService, error) {
+	ifaces, err := mdnsInterfaces(ifaceNames)
+	if err != nil {
+		return nil, err
+	}
+	b := &dnssdBrowser{
+		types:     []string{},
+		services:  map[string]*DNSSDService{},
+		hasSRV:    map[string]bool{},
+		addresses: map[string][]string{},
+	}
+	for _, t := range slices.Concat(dnssdServiceTypes, extraTypes) {
+		t = strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(t, "."), ".local"))
+		if t != "" && !slices.Contains(b.types, t) {
+			b.types = append(b.types, t)
+		}
+	}
+	asked := map[dnsmessage.Question]bool{}
+	questions, err := mdnsQuestions([]string{dnssdEnumerationName}, dnsmessage.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	asked[questions[0]] = true
+	questions = append(questions, b.pending(asked)...)
+	for round := 0; round < dnssdMaxRounds && len(questions) > 0; round++ {
+		log.Debug().Int("round", round).Int("questions", len(questions)).Msg("Browsing DNS-SD")
+		if err := mdnsQuery(questions, ifaces, b.add); err != nil {
+			return nil, err
+		}
+		questions = b.pending(asked)
+	}
+
+	services := []DNSSDService{}
+	for _, key := range b.order {
+		s := *b.services[key]
+		if s.Target != "" {
+			s.Addresses = append(s.Addresses, b.addresses[s.Target]...)
+		}
+		log.Debug().
+			Str("instance", s.Instance).
+			Str("type", s.Type).
+			Str("target", s.Target).
+			Strs("txt", s.TXT).
+			Str("interface", s.Interface).
+			Msg("DNS-SD service")
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// dnssdIndicatorTypes lists the service types named in the indicators so
+// they get browsed even when the enumeration doesn't list them
+func dnssdIndicatorTypes(dnssd_indicators map[string][]DNSSDIndicator) []string {
+	types := []string{}
+	for _, indicators := range dnssd_indicators {
+		for _, indicator := range indicators {
+			if indicator.ServiceType != "" {
+				types = append(types, indicator.ServiceType)
+			}
+		}
+	}
+	return types
+}
+
+// matchDNSSDIndicator returns the first of the instance, TXT and target
+// conditions that selects the service, or the service type when the
+// indicator only has one
+func matchDNSSDIndicator(indicator DNSSDIndicator, s DNSSDService) (string, string, bool) {
+	if indicator.ServiceType != "" && !strings.EqualFold(strings.TrimSuffix(strings.TrimSuffix(indicator.ServiceType, "."), ".local"), s.Type) {
+		return "", "", false
+	}
+	if indicator.Instance != "" && strings.Contains(strings.ToLower(s.Instance), strings.ToLower(indicator.Instance)) {
+		return "instance", s.Instance, true
+	}
+	if indicator.TXTKey != "" {
+		key, value, hasValue := strings.Cut(indicator.TXTKey, "=")
+		for _, txt := range s.TXT {
+			k, v, _ := strings.Cut(txt, "=")
+			if strings.EqualFold(k, key) && (!hasValue || strings.Contains(strings.ToLower(v), strings.ToLower(value))) {
+				return "txt", txt, true
+			}
+		}
+	}
+	if indicator.Target != "" && strings.Contains(s.Target, strings.ToLower(indicator.Target)) {
+		return "target", s.Target, true
+	}
+	if indicator.ServiceType != "" && indicator.Instance == "" && indicator.TXTKey == "" && indicator.Target == "" {
+		return "service", s.Type, true
+	}
+	return "", "", false
+}
+
+// checkDNSSD runs the dnssd indicators against every browsed service
+func checkDNSSD(dnssd_indicators map[string][]DNSSDIndicator, services []DNSSDService) []DNSSDFinding {
+	findings := []DNSSDFinding{}
+	vendors := slices.Sorted(maps.Keys(dnssd_indicators))
+	for _, s := range services {
+		for _, vendor := range vendors {
+			for _, indicator := range dnssd_indicators[vendor] {
+				matchType, value, ok := matchDNSSDIndicator(indicator, s)
+				if !ok {
+					continue
+				}
+				f := DNSSDFinding{
+					Vendor:     vendor,
+					Confidence: indicator.Confidence,
+					Type:       matchType,
+					Value:      value,
+					Service:    s,
+					Reference:  indicator.Reference,
+				}
+				if f.Confidence == "" {
+					f.Confidence = "low"
+				}
+				log.Info().
+					Str("vendor", f.Vendor).
+					Str("type", f.Type).
+					Str("value", f.Value).
+					Str("service", s.Type).
+					Str("interface", s.Interface).
+					Msg("DNS-SD service match found")
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings
+}
+
+// dnssdNames lists the instance names and SRV targets of the services so
+// the hostname indicators can run against them
+func dnssdNames(services []DNSSDService) []observedName {
+	names := []observedName{}
+	for _, s := range services {
+		addrs := []netip.Addr{}
+		for _, a := range s.Addresses {
+			if addr, err := netip.ParseAddr(a); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		for _, name := range []string{s.Instance + "." + s.Type + ".local", s.Target} {
+			if name == "" {
+				continue
+			}
+			names = append(names, observedName{
+				Name:      name,
+				Method:    "dnssd",
+				Source:    s.Source,
+				Interface: s.Interface,
+				Family:    s.Family,
+				Addrs:     addrs,
+			})
+		}
+	}
+	return names
+}
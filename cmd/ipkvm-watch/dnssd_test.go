@@ -0,0 +1,154 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestServiceType(t *testing.T) {
+	tests := []struct {
+		fullName string
+		instance string
+		t        string
+		ok       bool
+	}{
+		{"PiKVM._https._tcp.local.", "PiKVM", "_https._tcp", true},
+		{"PiKVM v4.local._https._tcp.local", "PiKVM v4.local", "_https._tcp", true},
+		{"PiKVM v4.local._https._tcp", "PiKVM v4.local", "_https._tcp", true},
+		{"glkvm [94:83:c4:ae:ac:2a]._workstation._TCP.local.", "glkvm [94:83:c4:ae:ac:2a]", "_workstation._tcp", true},
+		{"a._b._tcp._sub._http._tcp.local", "a._b._tcp._sub", "_http._tcp", true},
+		{"_https._tcp.local.", "", "", false},
+		{"PiKVM._https._sctp.local.", "", "", false},
+		{"PiKVM._https._tcp.example.", "", "", false},
+		{"pikvm.local.", "", "", false},
+	}
+	for _, tt := range tests {
+		instance, typ, ok := serviceType(tt.fullName)
+		if instance != tt.instance || typ != tt.t || ok != tt.ok {
+			t.Errorf("serviceType(%q) = %q, %q, %v, want %q, %q, %v", tt.fullName, instance, typ, ok, tt.instance, tt.t, tt.ok)
+		}
+	}
+}
+
+func dnssdResponse(t *testing.T, rrs ...dnsmessage.Resource) mdnsPacket {
+	t.Helper()
+	msg := packed(t, dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}, Answers: rrs})
+	return mdnsPacket{msg: msg, Interface: "eth0", Family: "ipv4", Source: "192.168.1.20"}
+}
+
+func dnssdRR(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 120},
+		Body:   body,
+	}
+}
+
+func questionStrings(questions []dnsmessage.Question) []string {
+	s := []string{}
+	for _, q := range questions {
+		s = append(s, strings.TrimPrefix(q.Type.String(), "Type")+" "+q.Name.String())
+	}
+	return s
+}
+
+func TestDNSSDBrowser(t *testing.T) {
+	b := &dnssdBrowser{
+		types:     []string{"_https._tcp"},
+		services:  map[string]*DNSSDService{},
+		hasSRV:    map[string]bool{},
+		addresses: map[string][]string{},
+	}
+	asked := map[dnsmessage.Question]bool{}
+	b.add(dnssdResponse(t,
+		dnssdRR(dnssdEnumerationName+".", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("_http._tcp.local.")}),
+		dnssdRR(dnssdEnumerationName+".", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("_https._tcp.local.")}),
+	))
+	want := []string{"PTR _https._tcp.local.", "PTR _http._tcp.local."}
+	if got := questionStrings(b.pending(asked)); !slices.Equal(got, want) {
+		t.Errorf("round 1: got %q, want %q", got, want)
+	}
+
+	// the first dotted instance comes with its SRV, TXT and address, the
+	// second can't be asked for, the plain one is
+	b.add(dnssdResponse(t,
+		dnssdRR("_https._tcp.local.", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("PiKVM._https._tcp.local.")}),
+		dnssdRR("_https._tcp.local.", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("Office KVM.lan._https._tcp.local.")}),
+		dnssdRR("_https._tcp.local.", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("PiKVM v4.local._https._tcp.local.")}),
+		dnssdRR("PiKVM v4.local._https._tcp.local.", &dnsmessage.SRVResource{Port: 443, Target: dnsmessage.MustNewName("pikvm-v4.local.")}),
+		dnssdRR("PiKVM v4.local._https._tcp.local.", &dnsmessage.TXTResource{TXT: []string{"model=v4plus"}}),
+		dnssdRR("pikvm-v4.local.", &dnsmessage.AResource{A: [4]byte{192, 168, 1, 21}}),
+	))
+	want = []string{"TXT pikvm._https._tcp.local.", "SRV pikvm._https._tcp.local."}
+	if got := questionStrings(b.pending(asked)); !slices.Equal(got, want) {
+		t.Errorf("round 2: got %q, want %q", got, want)
+	}
+
+	b.add(dnssdResponse(t,
+		dnssdRR("PiKVM._https._tcp.local.", &dnsmessage.SRVResource{Port: 443, Target: dnsmessage.MustNewName("pikvm.local.")}),
+		dnssdRR("PiKVM._https._tcp.local.", &dnsmessage.TXTResource{TXT: []string{"model=v3", "path=/", "model=v3"}}),
+	))
+	want = []string{"A pikvm.local.", "AAAA pikvm.local."}
+	if got := questionStrings(b.pending(asked)); !slices.Equal(got, want) {
+		t.Errorf("round 3: got %q, want %q", got, want)
+	}
+
+	b.add(dnssdResponse(t,
+		dnssdRR("pikvm.local.", &dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}}),
+		dnssdRR("pikvm.local.", &dnsmessage.AAAAResource{AAAA: [16]byte{0xfe, 0x80, 15: 1}}),
+	))
+	if got := b.pending(asked); len(got) != 0 {
+		t.Errorf("round 4: got %q, want nothing", questionStrings(got))
+	}
+
+	if len(b.order) != 3 {
+		t.Fatalf("got %d services, want 3: %v", len(b.order), b.order)
+	}
+	pikvm, dotted := b.services[b.order[0]], b.services[b.order[2]]
+	if pikvm.Instance != "PiKVM" || pikvm.Type != "_https._tcp" || pikvm.Target != "pikvm.local" || pikvm.Port != 443 {
+		t.Errorf("got %+v", pikvm)
+	}
+	if !slices.Equal(pikvm.TXT, []string{"model=v3", "path=/"}) {
+		t.Errorf("got TXT %q", pikvm.TXT)
+	}
+	if got := b.addresses[pikvm.Target]; !slices.Equal(got, []string{"192.168.1.20", "fe80::1%eth0"}) {
+		t.Errorf("got addresses %v", got)
+	}
+	if dotted.Instance != "PiKVM v4.local" || dotted.Target != "pikvm-v4.local" || !slices.Equal(b.addresses[dotted.Target], []string{"192.168.1.21"}) {
+		t.Errorf("got %+v %v", dotted, b.addresses[dotted.Target])
+	}
+}
+
+func TestMatchDNSSDIndicator(t *testing.T) {
+	s := DNSSDService{
+		Instance: "PiKVM v4",
+		Type:     "_https._tcp",
+		Target:   "pikvm.local",
+		TXT:      []string{"model=V4plus", "path=/"},
+	}
+	tests := []struct {
+		name      string
+		indicator DNSSDIndicator
+		matchType string
+		value     string
+		ok        bool
+	}{
+		{"instance", DNSSDIndicator{Instance: "pikvm"}, "instance", "PiKVM v4", true},
+		{"txt key", DNSSDIndicator{TXTKey: "PATH"}, "txt", "path=/", true},
+		{"txt key and value", DNSSDIndicator{TXTKey: "model=v4"}, "txt", "model=V4plus", true},
+		{"txt value differs", DNSSDIndicator{TXTKey: "model=v3"}, "", "", false},
+		{"target", DNSSDIndicator{Target: "PiKVM"}, "target", "pikvm.local", true},
+		{"type only", DNSSDIndicator{ServiceType: "_https._tcp.local."}, "service", "_https._tcp", true},
+		{"type and instance", DNSSDIndicator{ServiceType: "_https._tcp", Instance: "pikvm"}, "instance", "PiKVM v4", true},
+		{"type and missed instance", DNSSDIndicator{ServiceType: "_https._tcp", Instance: "glkvm"}, "", "", false},
+		{"other type", DNSSDIndicator{ServiceType: "_ssh._tcp", Instance: "pikvm"}, "", "", false},
+	}
+	for _, tt := range tests {
+		matchType, value, ok := matchDNSSDIndicator(tt.indicator, s)
+		if matchType != tt.matchType || value != tt.value || ok != tt.ok {
+			t.Errorf("%s: got %q %q %v, want %q %q %v", tt.name, matchType, value, ok, tt.matchType, tt.value, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// EDID is the decoded base block of an EDID blob
+type EDID struct {
+	ManufacturerID  string
+	ProductCode     uint16
+	SerialNumber    uint32
+	SerialString    string
+	Name            string
+	ManufactureWeek int // 1-54, 0 if not given
+	ManufactureYear int
+	ModelYear       bool // ManufactureYear is the model year, not when it was made
+	Version         string
+	Modes           []string
+}
+
+var edidHeader = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+// edidEstablishedModes are the modes behind the established timing bits,
+// bytes 35-37 from the most significant bit down
+var edidEstablishedModes = []string{
+	"720x400@70", "720x400@88", "640x480@60", "640x480@67", "640x480@72", "640x480@75", "800x600@56", "800x600@60",
+	"800x600@72", "800x600@75", "832x624@75", "1024x768@87", "1024x768@60", "1024x768@70", "1024x768@75", "1280x1024@75",
+	"1152x870@75",
+}
+
+// decodeEDID decodes the 128 byte base block. Extension blocks (CEA etc)
+// are ignored.
+func decodeEDID(b []byte) (EDID, error) {
+	e := EDID{Modes: []string{}}
+	if len(b) < 128 {
+		return e, fmt.Errorf("edid too short: %d bytes", len(b))
+	}
+	if !bytes.Equal(b[:8], edidHeader) {
+		return e, fmt.Errorf("edid header not found")
+	}
+	var sum byte
+	for _, c := range b[:128] {
+		sum += c
+	}
+	if sum != 0 {
+		return e, fmt.Errorf("edid checksum mismatch")
+	}
+
+	// three 5 bit letters, 1 = A
+	id := binary.BigEndian.Uint16(b[8:10])
+	e.ManufacturerID = string([]byte{
+		byte('A' - 1 + (id>>10)&0x1f),
+		byte('A' - 1 + (id>>5)&0x1f),
+		byte('A' - 1 + id&0x1f),
+	})
+	e.ProductCode = binary.LittleEndian.Uint16(b[10:12])
+	e.SerialNumber = binary.LittleEndian.Uint32(b[12:16])
+	// week 0xff flags the year as a model year (EDID 1.4)
+	if b[16] == 0xff {
+		e.ModelYear = true
+	} else {
+		e.ManufactureWeek = int(b[16])
+	}
+	e.ManufactureYear = int(b[17]) + 1990
+	e.Version = fmt.Sprintf("%d.%d", b[18], b[19])
+
+	// established timings
+	established := uint32(b[35])<<16 | uint32(b[36])<<8 | uint32(b[37])
+	for i, mode := range edidEstablishedModes {
+		if established&(1<<(23-i)) != 0 {
+			e.addMode(mode)
+		}
+	}
+
+	// standard timings, 0x0101 marks an unused slot
+	for i := 38; i < 54; i += 2 {
+		if b[i] == 0x01 && b[i+1] == 0x01 || b[i] == 0x00 {
+			continue
+		}
+		width := (int(b[i]) + 31) * 8
+		var height int
+		switch b[i+1] >> 6 {
+		case 0:
+			// 16:10 since EDID 1.3, 1:1 before it
+			if b[18] == 1 && b[19] < 3 {
+				height = width
+			} else {
+				height = width * 10 / 16
+			}
+		case 1:
+			height = width * 3 / 4
+		case 2:
+			height = width * 4 / 5
+		case 3:
+			height = width * 9 / 16
+		}
+		e.addMode(fmt.Sprintf("%dx%d@%d", width, height, int(b[i+1]&0x3f)+60))
+	}
+
+	// detailed timings and display descriptors
+	for i := 54; i < 126; i += 18 {
+		d := b[i : i+18]
+		clock := int(binary.LittleEndian.Uint16(d[0:2]))
+		if clock != 0 {
+			hActive := int(d[2]) | int(d[4]&0xf0)<<4
+			hBlank := int(d[3]) | int(d[4]&0x0f)<<8
+			vActive := int(d[5]) | int(d[7]&0xf0)<<4
+			vBlank := int(d[6]) | int(d[7]&0x0f)<<8
+			total := (hActive + hBlank) * (vActive + vBlank)
+			refresh := 0
+			if total > 0 {
+				// the pixel clock is in 10kHz units
+				refresh = (clock*10000 + total/2) / total
+			}
+			e.addMode(fmt.Sprintf("%dx%d@%d", hActive, vActive, refresh))
+			continue
+		}
+		switch d[3] {
+		case 0xfc:
+			e.Name = edidDescriptorText(d[5:18])
+		case 0xff:
+			e.SerialString = edidDescriptorText(d[5:18])
+		}
+	}
+	return e, nil
+}
+
+// addMode adds a mode once, the same mode is often in several timing lists
+func (e *EDID) addMode(mode string) {
+	if !slices.Contains(e.Modes, mode) {
+		e.Modes = append(e.Modes, mode)
+	}
+}
+
+// edidDescriptorText reads the text of a display descriptor, which ends at
+// a newline and is padded with spaces
+func edidDescriptorText(b []byte) string {
+	if i := bytes.IndexByte(b, 0x0a); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
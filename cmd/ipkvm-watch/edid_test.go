@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestDecodeEDID(t *testing.T) {
+	tests := []struct {
+		path string
+		want EDID
+	}{
+		{
+			path: "testdata/drm/card0-HDMI-A-1/edid",
+			want: EDID{
+				ManufacturerID:  "LNX",
+				ProductCode:     0x1234,
+				SerialString:    "CAFEBABE",
+				Name:            "PiKVM",
+				ManufactureWeek: 1,
+				ManufactureYear: 2022,
+				Version:         "1.3",
+				// aspect code 0 is 16:10 from EDID 1.3 on
+				Modes: []string{"640x480@60", "800x600@60", "1024x768@60", "1280x800@60", "1280x720@60", "1920x1080@60"},
+			},
+		},
+		{
+			path: "testdata/drm/card1-VGA-1/edid",
+			want: EDID{
+				ManufacturerID:  "ACR",
+				ProductCode:     0x00ad,
+				SerialNumber:    0x01020304,
+				Name:            "AL1511",
+				ManufactureWeek: 12,
+				ManufactureYear: 2001,
+				Version:         "1.2",
+				// and 1:1 before it
+				Modes: []string{"640x480@60", "800x600@60", "1024x768@60", "1024x1024@60"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := decodeEDID(b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if e.ManufacturerID != tt.want.ManufacturerID || e.ProductCode != tt.want.ProductCode ||
+			e.SerialNumber != tt.want.SerialNumber || e.SerialString != tt.want.SerialString ||
+			e.Name != tt.want.Name || e.ManufactureWeek != tt.want.ManufactureWeek ||
+			e.ManufactureYear != tt.want.ManufactureYear || e.ModelYear != tt.want.ModelYear ||
+			e.Version != tt.want.Version {
+			t.Errorf("%s: got %+v, want %+v", tt.path, e, tt.want)
+		}
+		if !slices.Equal(e.Modes, tt.want.Modes) {
+			t.Errorf("%s: got modes %v, want %v", tt.path, e.Modes, tt.want.Modes)
+		}
+	}
+}
+
+func TestDecodeEDIDModelYear(t *testing.T) {
+	b, err := os.ReadFile("testdata/drm/card0-HDMI-A-1/edid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// week 0xff, and the checksum byte fixed up to match
+	b = slices.Clone(b)
+	b[127] += b[16] - 0xff
+	b[16] = 0xff
+	e, err := decodeEDID(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.ModelYear || e.ManufactureWeek != 0 || e.ManufactureYear != 2022 {
+		t.Errorf("got week %d year %d model year %v", e.ManufactureWeek, e.ManufactureYear, e.ModelYear)
+	}
+	f, _ := matchDisplay("pikvm", DisplayIndicator{Name: "PiKVM"}, DisplayRecord{Connector: "card0-HDMI-A-1", EDID: e})
+	if !f.ModelYear || f.ManufactureWeek != 0 || f.ManufactureYear != 2022 {
+		t.Errorf("got finding %+v", f)
+	}
+}
+
+func TestDecodeEDIDErrors(t *testing.T) {
+	b, err := os.ReadFile("testdata/drm/card0-HDMI-A-1/edid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeEDID(b[:100]); err == nil {
+		t.Error("expected an error for a short edid")
+	}
+	corrupt := slices.Clone(b)
+	corrupt[20] ^= 0xff
+	if _, err := decodeEDID(corrupt); err == nil {
+		t.Error("expected a checksum error")
+	}
+}
+
+func TestReadDRMDisplays(t *testing.T) {
+	defer func(root string) { drmRoot = root }(drmRoot)
+	drmRoot = "testdata/drm"
+
+	displays, err := readDRMDisplays(drmRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HDMI-A-2 has nothing plugged in
+	if len(displays) != 2 || displays[0].Connector != "card0-HDMI-A-1" || displays[1].Connector != "card1-VGA-1" {
+		t.Fatalf("got displays %+v", displays)
+	}
+	indicator := DisplayIndicator{Name: "PiKVM", Confidence: "high"}
+	if f, ok := matchDisplay("pikvm", indicator, displays[0]); !ok || f.Confidence != "high" ||
+		f.ManufactureWeek != 1 || f.ManufactureYear != 2022 || f.ModelYear {
+		t.Errorf("PiKVM display didn't match: %+v", f)
+	}
+	if _, ok := matchDisplay("pikvm", indicator, displays[1]); ok {
+		t.Error("VGA monitor matched the PiKVM rule")
+	}
+}
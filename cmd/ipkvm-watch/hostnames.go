@@ -0,0 +1,84 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// observedName is a hostname one of the discovery phases came across, with
+// where it came from and the addresses it belongs to when known
+type observedName struct {
+	Name      string
+	Method    string // passive, dnssd, http, rdns or dhcp
+	Source    string
+	Interface string
+	Family    string
+	Addrs     []netip.Addr
+}
+
+// checkHostnames runs the mdns indicators, plain names, globs and regexes,
+// against names found by the other phases. A name is reported once per
+// vendor and method with the first pattern that matched it and the
+// addresses of every sighting.
+func checkHostnames(mdns_indicators map[string][]string, names []observedName) []MDNSResult {
+	found_domains := []MDNSResult{}
+	for _, vendor := range slices.Sorted(maps.Keys(mdns_indicators)) {
+		patterns := []namePattern{}
+		for _, domain := range mdns_indicators[vendor] {
+			pattern, err := parseNamePattern(domain)
+			if err != nil {
+				log.Warn().Err(err).Str("vendor", vendor).Msg("Skipping mDNS indicator")
+				continue
+			}
+			patterns = append(patterns, pattern)
+		}
+		results := map[[2]string]*MDNSResult{}
+		order := [][2]string{}
+		for _, n := range names {
+			name := canonicalName(n.Name)
+			if name == "" {
+				continue
+			}
+			key := [2]string{name, n.Method}
+			a, ok := results[key]
+			if !ok {
+				i := slices.IndexFunc(patterns, func(p namePattern) bool { return p.match(name) })
+				if i < 0 {
+					continue
+				}
+				a = &MDNSResult{
+					Domain:    name,
+					Vendor:    vendor,
+					IPv4s:     []net.IP{},
+					IPv6s:     []*net.IPAddr{},
+					Source:    n.Source,
+					Method:    n.Method,
+					Interface: n.Interface,
+					Family:    n.Family,
+					Pattern:   patterns[i].raw,
+				}
+				results[key] = a
+				order = append(order, key)
+			}
+			for _, addr := range n.Addrs {
+				a.addAddr(addr)
+			}
+		}
+		for _, key := range order {
+			a := results[key]
+			log.Info().
+				Str("vendor", vendor).
+				Str("name", a.Domain).
+				Str("pattern", a.Pattern).
+				Str("method", a.Method).
+				Str("src", a.Source).
+				Msg("Hostname match found")
+			found_domains = append(found_domains, *a)
+		}
+	}
+	return found_domains
+}
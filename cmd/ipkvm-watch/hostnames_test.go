@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestCheckHostnames(t *testing.T) {
+	var logs bytes.Buffer
+	defer func(l zerolog.Logger) { log.Logger = l }(log.Logger)
+	log.Logger = zerolog.New(&logs)
+
+	indicators := map[string][]string{
+		"PiKVM": {"kvm[.local", `re:^pi-?kvm[0-9]*\.local$`, "*-kvm*.local"},
+		"GLKVM": {"re:gl(kvm", "glkvm.local"},
+	}
+	names := []observedName{
+		{Name: "PiKVM.local.", Method: "passive", Source: "192.168.1.20", Addrs: []netip.Addr{netip.MustParseAddr("192.168.1.20")}},
+		{Name: "pikvm.local", Method: "passive", Addrs: []netip.Addr{
+			netip.MustParseAddr("192.168.1.20"),
+			netip.MustParseAddr("fe80::1%eth0"),
+		}},
+		{Name: "pikvm.local", Method: "dnssd", Addrs: []netip.Addr{netip.MustParseAddr("192.168.1.21")}},
+		{Name: "my-kvm.local", Method: "rdns"},
+		{Name: "glkvm.local", Method: "dhcp", Addrs: []netip.Addr{netip.MustParseAddr("192.168.8.1")}},
+		{Name: "printer.local", Method: "passive"},
+		{Name: "", Method: "http"},
+	}
+	results := checkHostnames(indicators, names)
+
+	want := []struct {
+		vendor, name, method, pattern string
+		addrs                         []string
+	}{
+		{"GLKVM", "glkvm.local", "dhcp", "glkvm.local", []string{"192.168.8.1"}},
+		{"PiKVM", "pikvm.local", "passive", `re:^pi-?kvm[0-9]*\.local$`, []string{"192.168.1.20", "fe80::1%eth0"}},
+		{"PiKVM", "pikvm.local", "dnssd", `re:^pi-?kvm[0-9]*\.local$`, []string{"192.168.1.21"}},
+		{"PiKVM", "my-kvm.local", "rdns", "*-kvm*.local", []string{}},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, w := range want {
+		r := results[i]
+		addrs := []string{}
+		for _, ip := range r.IPv4s {
+			addrs = append(addrs, ip.String())
+		}
+		for _, ip := range r.IPv6s {
+			addrs = append(addrs, ip.String())
+		}
+		if r.Vendor != w.vendor || r.Domain != w.name || r.Method != w.method || r.Pattern != w.pattern {
+			t.Errorf("result %d: got %s %s %s %q, want %s %s %s %q", i, r.Vendor, r.Domain, r.Method, r.Pattern, w.vendor, w.name, w.method, w.pattern)
+		}
+		if strings.Join(addrs, " ") != strings.Join(w.addrs, " ") {
+			t.Errorf("result %d: got addresses %v, want %v", i, addrs, w.addrs)
+		}
+	}
+	if results[1].Source != "192.168.1.20" {
+		t.Errorf("got source %q from the first sighting", results[1].Source)
+	}
+
+	for _, pattern := range []string{"kvm[.local", "re:gl(kvm"} {
+		if !strings.Contains(logs.String(), `"level":"warn"`) || !strings.Contains(logs.String(), pattern) {
+			t.Errorf("no warning about %q: %s", pattern, logs.String())
+		}
+	}
+}
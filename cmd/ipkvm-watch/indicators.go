@@ -8,9 +8,11 @@ import (
 )
 
 type Config struct {
-	Network NetworkConfig          `yaml:"network"`
-	HTTP    HTTPConfig             `yaml:"http"`
-	USB     map[string][]USBDevice `yaml:"usb"`
+	Network NetworkConfig                 `yaml:"network"`
+	HTTP    HTTPConfig                    `yaml:"http"`
+	USB     map[string][]USBDevice        `yaml:"usb"`
+	Display map[string][]DisplayIndicator `yaml:"display"`
+	HID     HIDConfig                     `yaml:"hid"`
 }
 
 func GetConfig(path string) *Config {
@@ -32,8 +34,10 @@ func GetConfig(path string) *Config {
 
 // NetworkConfig holds all network-related configurations.
 type NetworkConfig struct {
-	MDNS         map[string][]string       `yaml:"mdns"`
-	MACAddresses map[string]MACPrefixGroup `yaml:"mac_addresses"`
+	MDNS         map[string][]string         `yaml:"mdns"`
+	MACAddresses map[string]MACPrefixGroup   `yaml:"mac_addresses"`
+	DNSSD        map[string][]DNSSDIndicator `yaml:"dnssd"`
+	SSDP         map[string][]SSDPIndicator  `yaml:"ssdp"`
 }
 
 // MDNSConfig maps KVM names to a list of mDNS entries.
@@ -47,11 +51,42 @@ type MACPrefixGroup struct {
 	Prefixes []MACPrefixEntry `yaml:"prefixes"`
 }
 
-// MACPrefixEntry defines a single MAC address prefix rule.
+// MACPrefixEntry defines a single MAC address prefix rule. Prefix takes
+// any number of hex digits (28 and 36 bits for MA-M/MA-S) or a mask like
+// 02:00:00:00:00:00/8. The bit, class and organization conditions have to
+// hold as well when they are set, they can also be used without a prefix.
 type MACPrefixEntry struct {
-	Prefix     string `yaml:"prefix"`
-	Confidence string `yaml:"confidence"`
-	Reference  string `yaml:"reference,omitempty"` // omitempty for optional fields
+	Prefix              string `yaml:"prefix"`
+	LocallyAdministered *bool  `yaml:"locally_administered,omitempty"`
+	Multicast           *bool  `yaml:"multicast,omitempty"`
+	Class               string `yaml:"class,omitempty"`        // globally-unique, locally-administered or randomized
+	Organization        string `yaml:"organization,omitempty"` // part of the IEEE registered organization name, ex: 'Raspberry Pi'
+	Confidence          string `yaml:"confidence"`
+	Reference           string `yaml:"reference,omitempty"` // omitempty for optional fields
+}
+
+// DNSSDIndicator matches an advertised DNS-SD service. service_type limits
+// the match to one type and selects every instance of it when nothing else
+// is set, instance, txt_key and target each select a service on their own.
+type DNSSDIndicator struct {
+	ServiceType string `yaml:"service_type,omitempty"` // ex: '_https._tcp'
+	Instance    string `yaml:"instance,omitempty"`     // part of the instance name, ex: 'PiKVM'
+	TXTKey      string `yaml:"txt_key,omitempty"`      // key or key=part of the value
+	Target      string `yaml:"target,omitempty"`       // part of the SRV target host
+	Confidence  string `yaml:"confidence,omitempty"`
+	Reference   string `yaml:"reference,omitempty"`
+}
+
+// SSDPIndicator matches the UPnP description of a device that answered an
+// SSDP search. Each field is part of the value, case insensitive, and
+// selects a device on its own.
+type SSDPIndicator struct {
+	FriendlyName string `yaml:"friendly_name,omitempty"`
+	Manufacturer string `yaml:"manufacturer,omitempty"` // ex: 'GL Technologies'
+	ModelName    string `yaml:"model_name,omitempty"`
+	SerialNumber string `yaml:"serial_number,omitempty"`
+	Confidence   string `yaml:"confidence,omitempty"`
+	Reference    string `yaml:"reference,omitempty"`
 }
 
 // --- HTTP Section ---
@@ -78,10 +113,59 @@ type HTTPConfig struct {
 // type USBConfig map[string][]USBDevice `yaml:",inline"`
 
 // USBDevice defines the configuration for a specific USB device.
+// vid/pid, serial, serial_regex, manufacturer, product and
+// windows_search_string each select a device on their own, while
+// interface_classes and the bcd_device range must also hold when set.
 type USBDevice struct {
-	VID                 string `yaml:"vid"`
-	PID                 string `yaml:"pid"`
-	Serial              string `yaml:"serial"`
-	Manufacturer        string `yaml:"manufacturer"`
-	WindowsSearchString string `yaml:"windows_search_sring"`
+	VID                 string   `yaml:"vid"`
+	PID                 string   `yaml:"pid"`
+	Serial              string   `yaml:"serial"`
+	SerialRegex         string   `yaml:"serial_regex,omitempty"`
+	Manufacturer        string   `yaml:"manufacturer"`
+	Product             string   `yaml:"product,omitempty"`
+	InterfaceClasses    []string `yaml:"interface_classes,omitempty"` // hex class[:subclass[:protocol]], ex: '03:01:01'
+	BCDDeviceMin        string   `yaml:"bcd_device_min,omitempty"`    // ex: '1.00'
+	BCDDeviceMax        string   `yaml:"bcd_device_max,omitempty"`
+	WindowsSearchString string   `yaml:"windows_search_string"`
+	Confidence          string   `yaml:"confidence,omitempty"`
+	Reference           string   `yaml:"reference,omitempty"`
+}
+
+// UnmarshalYAML also accepts the old misspelled windows_search_sring key so
+// existing indicator files keep working
+func (d *USBDevice) UnmarshalYAML(value *yaml.Node) error {
+	type plainUSBDevice USBDevice
+	raw := struct {
+		plainUSBDevice     `yaml:",inline"`
+		LegacySearchString string `yaml:"windows_search_sring"`
+	}{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*d = USBDevice(raw.plainUSBDevice)
+	if d.WindowsSearchString == "" {
+		d.WindowsSearchString = raw.LegacySearchString
+	}
+	return nil
+}
+
+// --- Display Section ---
+
+// DisplayIndicator matches the EDID a KVM's capture side presents to the host.
+type DisplayIndicator struct {
+	ManufacturerID string `yaml:"manufacturer_id"`        // three letter PNP id, ex: 'LNX'
+	ProductCode    string `yaml:"product_code,omitempty"` // only checked together with manufacturer_id, ex: '0x1234'
+	Name           string `yaml:"name,omitempty"`         // monitor name descriptor
+	Serial         string `yaml:"serial,omitempty"`
+	Confidence     string `yaml:"confidence,omitempty"`
+	Reference      string `yaml:"reference,omitempty"`
+}
+
+// --- HID Section ---
+
+// HIDConfig holds the input device indicators.
+type HIDConfig struct {
+	// ReportDescriptors maps KVM names to md5 hashes of their HID report
+	// descriptors (/sys/bus/hid/devices/*/report_descriptor)
+	ReportDescriptors map[string][]string `yaml:"report_descriptors"`
 }
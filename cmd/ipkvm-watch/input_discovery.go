@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// procInputDevices and hidDevicesRoot are variables so captured copies can
+// be used instead
+var (
+	procInputDevices = "/proc/bus/input/devices"
+	hidDevicesRoot   = "/sys/bus/hid/devices"
+)
+
+// input event types and codes from linux/input-event-codes.h
+const (
+	evKey   = 0x01
+	evAbs   = 0x03
+	keyA    = 30
+	btnLeft = 0x110
+	absX    = 0x00
+	absY    = 0x01
+)
+
+// InputDevice is one block of /proc/bus/input/devices
+type InputDevice struct {
+	Bus       string
+	Vendor    string
+	Product   string
+	Name      string
+	Phys      string
+	Sysfs     string
+	Handlers  []string
+	Bitmaps   map[string]string // B: lines, ex: EV, KEY, ABS
+	USBParent string            // ex: 1-1.2
+}
+
+// HIDDevice is a /sys/bus/hid/devices entry and the md5 of its report descriptor
+type HIDDevice struct {
+	ID             string // ex: 0003:1D6B:0104.0001
+	DescriptorHash string
+	USBParent      string
+}
+
+type InputFinding struct {
+	Vendor     string
+	Confidence string
+	Type       string
+	USBParent  string
+	VID        string
+	PID        string
+	Devices    []string
+	Value      string
+}
+
+// usbInterfaceSegment matches the interface part of a sysfs path, ex: 1-1.2:1.0
+var usbInterfaceSegment = regexp.MustCompile(`^(\d+-[\d.]+):\d+\.\d+$`)
+
+// parseProcInputDevices parses the blank line separated blocks of
+// /proc/bus/input/devices
+func parseProcInputDevices(input string) []InputDevice {
+	devices := []InputDevice{}
+	var dev *InputDevice
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			dev = nil
+			continue
+		}
+		if dev == nil {
+			devices = append(devices, InputDevice{Bitmaps: map[string]string{}})
+			dev = &devices[len(devices)-1]
+		}
+		kind, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "I":
+			// ex: Bus=0003 Vendor=1d6b Product=0104 Version=0101
+			for _, field := range strings.Fields(value) {
+				k, v, _ := strings.Cut(field, "=")
+				switch k {
+				case "Bus":
+					dev.Bus = v
+				case "Vendor":
+					dev.Vendor = strings.ToLower(v)
+				case "Product":
+					dev.Product = strings.ToLower(v)
+				}
+			}
+		case "N":
+			dev.Name = strings.Trim(strings.TrimPrefix(value, "Name="), `"`)
+		case "P":
+			dev.Phys = strings.TrimPrefix(value, "Phys=")
+		case "S":
+			dev.Sysfs = strings.TrimPrefix(value, "Sysfs=")
+			dev.USBParent = usbParentFromPath(dev.Sysfs)
+		case "H":
+			dev.Handlers = strings.Fields(strings.TrimPrefix(value, "Handlers="))
+		case "B":
+			k, v, _ := strings.Cut(value, "=")
+			dev.Bitmaps[k] = v
+		}
+	}
+	return devices
+}
+
+// usbParentFromPath returns the usb device a sysfs path belongs to
+// ex: /devices/.../usb1/1-1/1-1:1.0/0003:1D6B:0104.0001/input/input5 -> 1-1
+func usbParentFromPath(path string) string {
+	for _, segment := range strings.Split(path, "/") {
+		if m := usbInterfaceSegment.FindStringSubmatch(segment); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// hasBit checks a bit in a /proc/bus/input/devices bitmap. The bitmap is
+// printed as space separated longs, most significant first.
+func hasBit(bitmap string, bit int) bool {
+	words := strings.Fields(bitmap)
+	index := len(words) - 1 - bit/bits.UintSize
+	if index < 0 || index >= len(words) {
+		return false
+	}
+	word, err := strconv.ParseUint(words[index], 16, bits.UintSize)
+	if err != nil {
+		return false
+	}
+	return word&(1<<(bit%bits.UintSize)) != 0
+}
+
+func (d InputDevice) isKeyboard() bool {
+	return hasBit(d.Bitmaps["EV"], evKey) && hasBit(d.Bitmaps["KEY"], keyA) && slices.Contains(d.Handlers, "kbd")
+}
+
+func (d InputDevice) isAbsolutePointer() bool {
+	return hasBit(d.Bitmaps["EV"], evAbs) && hasBit(d.Bitmaps["ABS"], absX) && hasBit(d.Bitmaps["ABS"], absY) &&
+		hasBit(d.Bitmaps["KEY"], btnLeft)
+}
+
+// readHIDDevices hashes the report descriptor of every hid device under root
+func readHIDDevices(root string) ([]HIDDevice, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	devices := []HIDDevice{}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		b, err := os.ReadFile(filepath.Join(path, "report_descriptor"))
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		hasher := md5.New()
+		hasher.Write(b)
+		dev := HIDDevice{
+			ID:             entry.Name(),
+			DescriptorHash: hex.EncodeToString(hasher.Sum(nil)),
+		}
+		// the entries are symlinks into the usb device tree
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			dev.USBParent = usbParentFromPath(resolved)
+		}
+		log.Debug().Str("hid", dev.ID).Str("hash", dev.DescriptorHash).Msg("Discovered HID report descriptor")
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// hidIDParts splits a hid id like 0003:1D6B:0104.0001 into the vid and pid
+func hidIDParts(id string) (string, string) {
+	parts := strings.Split(strings.Split(id, ".")[0], ":")
+	if len(parts) != 3 {
+		return "", ""
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+// checkInputCombos flags usb devices that are both a keyboard and an
+// absolute pointer, which is how KVMs drive the host. Real keyboard/mouse
+// combos almost always use a relative mouse.
+func checkInputCombos(devices []InputDevice) []InputFinding {
+	findings := []InputFinding{}
+	byParent := map[string][]InputDevice{}
+	parents := []string{}
+	for _, d := range devices {
+		if d.USBParent == "" {
+			continue
+		}
+		if _, ok := byParent[d.USBParent]; !ok {
+			parents = append(parents, d.USBParent)
+		}
+		byParent[d.USBParent] = append(byParent[d.USBParent], d)
+	}
+	for _, parent := range parents {
+		hasKeyboard, hasAbsolute := false, false
+		names := []string{}
+		for _, d := range byParent[parent] {
+			if d.isKeyboard() {
+				hasKeyboard = true
+			}
+			if d.isAbsolutePointer() {
+				hasAbsolute = true
+			}
+			names = append(names, d.Name)
+		}
+		if !hasKeyboard || !hasAbsolute {
+			continue
+		}
+		first := byParent[parent][0]
+		f := InputFinding{
+			Vendor:     "unknown",
+			Confidence: "medium",
+			Type:       "keyboard+absolute pointer",
+			USBParent:  parent,
+			VID:        first.Vendor,
+			PID:        first.Product,
+			Devices:    names,
+		}
+		findings = append(findings, f)
+		log.Info().
+			Str("usb_parent", parent).
+			Str("vid", f.VID).
+			Str("pid", f.PID).
+			Strs("devices", names).
+			Msg("Keyboard and absolute pointer on one USB device")
+	}
+	return findings
+}
+
+// checkHIDDescriptors matches report descriptor hashes against the indicators
+func checkHIDDescriptors(devices []HIDDevice, descriptorIndicators map[string][]string) []InputFinding {
+	findings := []InputFinding{}
+	for _, d := range devices {
+		for vendor, hashes := range descriptorIndicators {
+			if !containsFold(hashes, d.DescriptorHash) {
+				continue
+			}
+			vid, pid := hidIDParts(d.ID)
+			f := InputFinding{
+				Vendor:     vendor,
+				Confidence: "high",
+				Type:       "report_descriptor",
+				USBParent:  d.USBParent,
+				VID:        vid,
+				PID:        pid,
+				Devices:    []string{d.ID},
+				Value:      d.DescriptorHash,
+			}
+			findings = append(findings, f)
+			log.Info().
+				Str("vendor", vendor).
+				Str("hid", d.ID).
+				Str("hash", d.DescriptorHash).
+				Msg("Matched HID report descriptor")
+		}
+	}
+	return findings
+}
+
+func checkInputDevices(hidIndicators HIDConfig) []InputFinding {
+	findings := []InputFinding{}
+	if runtime.GOOS != "linux" {
+		log.Warn().Str("os", runtime.GOOS).Msg("Input device discovery not supported on this OS")
+		return findings
+	}
+	b, err := os.ReadFile(procInputDevices)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read input devices")
+	} else {
+		findings = append(findings, checkInputCombos(parseProcInputDevices(string(b)))...)
+	}
+	hidDevices, err := readHIDDevices(hidDevicesRoot)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read HID devices")
+	} else {
+		findings = append(findings, checkHIDDescriptors(hidDevices, hidIndicators.ReportDescriptors)...)
+	}
+	return findings
+}
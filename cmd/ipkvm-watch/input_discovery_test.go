@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseProcInputDevices(t *testing.T) {
+	devices := parseProcInputDevices(readFixture(t, "testdata/input/devices"))
+	if len(devices) != 6 {
+		t.Fatalf("got %d devices, want 6", len(devices))
+	}
+	kvm := devices[4]
+	if kvm.Bus != "0003" || kvm.Vendor != "1d6b" || kvm.Product != "0104" || kvm.Name != "PiKVM Composite KVM Device" {
+		t.Errorf("got %+v", kvm)
+	}
+	if kvm.USBParent != "1-1" || !slices.Equal(kvm.Handlers, []string{"sysrq", "kbd", "event5", "leds"}) || kvm.Bitmaps["EV"] != "120013" {
+		t.Errorf("got parent %q handlers %v bitmaps %v", kvm.USBParent, kvm.Handlers, kvm.Bitmaps)
+	}
+	if devices[0].USBParent != "" {
+		t.Errorf("power button has a usb parent %q", devices[0].USBParent)
+	}
+
+	kinds := []struct {
+		keyboard, absolute bool
+	}{{false, false}, {true, false}, {false, false}, {true, false}, {true, false}, {false, true}}
+	for i, d := range devices {
+		if d.isKeyboard() != kinds[i].keyboard || d.isAbsolutePointer() != kinds[i].absolute {
+			t.Errorf("%s: keyboard %v absolute pointer %v", d.Name, d.isKeyboard(), d.isAbsolutePointer())
+		}
+	}
+}
+
+func TestHasBit(t *testing.T) {
+	tests := []struct {
+		bitmap string
+		bit    int
+		set    bool
+	}{
+		{"3", absX, true},
+		{"3", absY, true},
+		{"3", 2, false},
+		{"100000000", 32, true},
+		{"1f0000 0 0 0 0", btnLeft, true},
+		{"1f0000 0 0 0 0", btnLeft - 1, false},
+		{"1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe", keyA, true},
+		{"1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe", 0, false},
+		{"1f", 5 * 64, false},
+		{"", 0, false},
+		{"zz", 0, false},
+	}
+	for _, tt := range tests {
+		if got := hasBit(tt.bitmap, tt.bit); got != tt.set {
+			t.Errorf("hasBit(%q, %d) = %v, want %v", tt.bitmap, tt.bit, got, tt.set)
+		}
+	}
+}
+
+func TestCheckInputCombos(t *testing.T) {
+	findings := checkInputCombos(parseProcInputDevices(readFixture(t, "testdata/input/devices")))
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1: %+v", len(findings), findings)
+	}
+	f := findings[0]
+	if f.Type != "keyboard+absolute pointer" || f.Confidence != "medium" || f.USBParent != "1-1" || f.VID != "1d6b" || f.PID != "0104" {
+		t.Errorf("got %+v", f)
+	}
+	if !slices.Equal(f.Devices, []string{"PiKVM Composite KVM Device", "PiKVM Composite KVM Device Mouse"}) {
+		t.Errorf("got devices %q", f.Devices)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// MAC classes from the classifier
+const (
+	macClassGlobal    = "globally-unique"
+	macClassLocal     = "locally-administered"
+	macClassRandom    = "randomized"
+	macClassMulticast = "multicast"
+)
+
+// first octet bits
+const (
+	macBitMulticast = 0x01
+	macBitLocal     = 0x02
+)
+
+// macPrefix is a parsed prefix rule, the first bits of value are compared
+type macPrefix struct {
+	value [6]byte
+	bits  int
+	kind  string // oui, oui-28, oui-36, prefix or mask
+}
+
+// parseMACPrefix reads "28:CD:C1:", "04:C9:8B:1" (28 bits), "70-B3-D5-12-3"
+// (36 bits) or mask syntax like "02:00:00:00:00:00/8". Without a mask the
+// length is the number of hex digits given.
+func parseMACPrefix(prefix string) (macPrefix, error) {
+	p := macPrefix{}
+	digits, mask, hasMask := strings.Cut(strings.TrimSpace(prefix), "/")
+	digits = strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(digits))
+	if digits == "" || len(digits) > 12 {
+		return p, fmt.Errorf("bad MAC prefix %q", prefix)
+	}
+	p.bits = len(digits) * 4
+	b, err := hex.DecodeString(digits + strings.Repeat("0", 12-len(digits)))
+	if err != nil {
+		return p, fmt.Errorf("bad MAC prefix %q: %w", prefix, err)
+	}
+	copy(p.value[:], b)
+	switch {
+	case hasMask:
+		n, err := strconv.Atoi(mask)
+		if err != nil || n < 1 || n > p.bits {
+			return p, fmt.Errorf("bad MAC prefix length in %q", prefix)
+		}
+		p.bits = n
+		p.kind = "mask"
+	case p.bits == 24:
+		p.kind = "oui"
+	case p.bits == 28:
+		p.kind = "oui-28"
+	case p.bits == 36:
+		p.kind = "oui-36"
+	default:
+		p.kind = "prefix"
+	}
+	return p, nil
+}
+
+func (p macPrefix) match(mac net.HardwareAddr) bool {
+	if len(mac) != 6 {
+		return false
+	}
+	for i := 0; i < p.bits; i++ {
+		bit := byte(0x80 >> (i % 8))
+		if mac[i/8]&bit != p.value[i/8]&bit {
+			return false
+		}
+	}
+	return true
+}
+
+// classifyMAC labels a MAC by its first octet bits. Locally administered
+// MACs in the SLAP AAI or reserved quadrants (second digit 2 or 6) with no
+// zero octet in them look random, assigned ones (docker 02:42:ac:11:00:02,
+// qemu 52:54:00:...) almost always have one.
+func classifyMAC(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+	switch {
+	case hw[0]&macBitMulticast != 0:
+		return macClassMulticast
+	case hw[0]&macBitLocal == 0:
+		return macClassGlobal
+	}
+	// 802c SLAP quadrants: ELI xA and SAI xE are structured assignments
+	if quadrant := hw[0] & 0x0f; quadrant == 0x0a || quadrant == 0x0e {
+		return macClassLocal
+	}
+	for _, b := range hw[1:] {
+		if b == 0x00 {
+			return macClassLocal
+		}
+	}
+	return macClassRandom
+}
+
+// matchMACEntry checks every condition set on the entry and returns the
+// rule type that matched, ex: oui, oui-28, mask, bits or oui+bits. org is
+// the MAC's registered organization from the OUI registry.
+func matchMACEntry(entry MACPrefixEntry, prefix *macPrefix, mac string, org string) (string, bool) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", false
+	}
+	kinds := []string{}
+	if prefix != nil {
+		if !prefix.match(hw) {
+			return "", false
+		}
+		kinds = append(kinds, prefix.kind)
+	}
+	bits := false
+	if entry.LocallyAdministered != nil {
+		if (hw[0]&macBitLocal != 0) != *entry.LocallyAdministered {
+			return "", false
+		}
+		bits = true
+	}
+	if entry.Multicast != nil {
+		if (hw[0]&macBitMulticast != 0) != *entry.Multicast {
+			return "", false
+		}
+		bits = true
+	}
+	if bits {
+		kinds = append(kinds, "bits")
+	}
+	if entry.Class != "" {
+		if classifyMAC(mac) != entry.Class {
+			return "", false
+		}
+		kinds = append(kinds, "class")
+	}
+	if entry.Organization != "" {
+		if org == "" || !strings.Contains(strings.ToLower(org), strings.ToLower(entry.Organization)) {
+			return "", false
+		}
+		kinds = append(kinds, "organization")
+	}
+	if len(kinds) == 0 {
+		return "", false
+	}
+	return strings.Join(kinds, "+"), true
+}
@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func TestParseMACPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		kind   string
+		bits   int
+		ok     bool
+	}{
+		{"28:CD:C1:", "oui", 24, true},
+		{"28-cd-c1", "oui", 24, true},
+		{"04:C9:8B:1", "oui-28", 28, true},
+		{"70-B3-D5-12-3", "oui-36", 36, true},
+		{"b827.eb5c", "prefix", 32, true},
+		{"02:00:00:00:00:00/8", "mask", 8, true},
+		{"02/7", "mask", 7, true},
+		{"02/9", "", 0, false},
+		{"02:00/0", "", 0, false},
+		{"zz:00:00", "", 0, false},
+		{"", "", 0, false},
+		{"00:11:22:33:44:55:66", "", 0, false},
+	}
+	for _, tt := range tests {
+		p, err := parseMACPrefix(tt.prefix)
+		if (err == nil) != tt.ok || (tt.ok && (p.kind != tt.kind || p.bits != tt.bits)) {
+			t.Errorf("%q: got %s/%d %v, want %s/%d ok %v", tt.prefix, p.kind, p.bits, err, tt.kind, tt.bits, tt.ok)
+		}
+	}
+}
+
+func TestClassifyMAC(t *testing.T) {
+	tests := map[string]string{
+		"94:83:c4:ae:ac:2a": macClassGlobal,
+		"01:00:5e:00:00:fb": macClassMulticast,
+		"33:33:00:00:00:01": macClassMulticast,
+		"02:42:ac:11:00:02": macClassLocal,  // docker
+		"52:54:00:12:34:56": macClassLocal,  // qemu
+		"da:a1:19:5c:7e:31": macClassLocal,  // ELI quadrant
+		"ae:11:22:33:44:55": macClassLocal,  // SAI quadrant
+		"f2:3b:9e:21:c4:7d": macClassRandom, // AAI quadrant
+		"36:8f:d2:47:1b:e9": macClassRandom, // reserved quadrant
+		"not a mac":         "",
+	}
+	for mac, want := range tests {
+		if got := classifyMAC(mac); got != want {
+			t.Errorf("%s: got %q, want %q", mac, got, want)
+		}
+	}
+}
+
+func TestMatchMACEntry(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		entry MACPrefixEntry
+		mac   string
+		org   string
+		kind  string
+		ok    bool
+	}{
+		{"oui", MACPrefixEntry{Prefix: "94:83:C4"}, "94:83:c4:ae:ac:2a", "", "oui", true},
+		{"oui other vendor", MACPrefixEntry{Prefix: "94:83:C4"}, "b8:27:eb:5c:10:8e", "", "", false},
+		// the TinyPilot MA-M block in indicators.yaml, the next block over belongs to someone else
+		{"oui-28", MACPrefixEntry{Prefix: "04:C9:8B:1"}, "04:c9:8b:1a:bc:de", "", "oui-28", true},
+		{"oui-28 neighbor block", MACPrefixEntry{Prefix: "04:C9:8B:1"}, "04:c9:8b:2a:bc:de", "", "", false},
+		{"oui-36", MACPrefixEntry{Prefix: "70:B3:D5:12:3"}, "70:b3:d5:12:3f:01", "", "oui-36", true},
+		{"oui-36 neighbor block", MACPrefixEntry{Prefix: "70:B3:D5:12:3"}, "70:b3:d5:12:4f:01", "", "", false},
+		{"mask", MACPrefixEntry{Prefix: "02:00:00:00:00:00/7"}, "03:12:34:56:78:9a", "", "mask", true},
+		{"mask miss", MACPrefixEntry{Prefix: "02:00:00:00:00:00/7"}, "06:12:34:56:78:9a", "", "", false},
+		{"locally administered", MACPrefixEntry{LocallyAdministered: &yes}, "f6:3b:9e:21:c4:7d", "", "bits", true},
+		{"not locally administered", MACPrefixEntry{LocallyAdministered: &yes}, "94:83:c4:ae:ac:2a", "", "", false},
+		{"globally unique", MACPrefixEntry{LocallyAdministered: &no}, "94:83:c4:ae:ac:2a", "", "bits", true},
+		{"multicast", MACPrefixEntry{Multicast: &yes}, "01:00:5e:00:00:fb", "", "bits", true},
+		{"not multicast", MACPrefixEntry{Multicast: &no}, "01:00:5e:00:00:fb", "", "", false},
+		{"prefix and bits", MACPrefixEntry{Prefix: "f6", LocallyAdministered: &yes, Multicast: &no}, "f6:3b:9e:21:c4:7d", "", "prefix+bits", true},
+		{"class", MACPrefixEntry{Class: macClassRandom}, "f6:3b:9e:21:c4:7d", "", "class", true},
+		{"class miss", MACPrefixEntry{Class: macClassRandom}, "02:42:ac:11:00:02", "", "", false},
+		{"organization", MACPrefixEntry{Organization: "raspberry pi"}, "b8:27:eb:5c:10:8e", "Raspberry Pi Foundation", "organization", true},
+		{"organization unknown", MACPrefixEntry{Organization: "raspberry pi"}, "b8:27:eb:5c:10:8e", "", "", false},
+		{"oui and organization", MACPrefixEntry{Prefix: "b8:27:eb", Organization: "Raspberry"}, "b8:27:eb:5c:10:8e", "Raspberry Pi Foundation", "oui+organization", true},
+		{"nothing set", MACPrefixEntry{}, "b8:27:eb:5c:10:8e", "", "", false},
+		{"bad mac", MACPrefixEntry{Prefix: "b8:27:eb"}, "b8:27:eb", "", "", false},
+	}
+	for _, tt := range tests {
+		var prefix *macPrefix
+		if tt.entry.Prefix != "" {
+			p, err := parseMACPrefix(tt.entry.Prefix)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			prefix = &p
+		}
+		kind, ok := matchMACEntry(tt.entry, prefix, tt.mac, tt.org)
+		if kind != tt.kind || ok != tt.ok {
+			t.Errorf("%s: got %q %v, want %q %v", tt.name, kind, ok, tt.kind, tt.ok)
+		}
+	}
+}
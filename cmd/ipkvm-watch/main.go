@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -14,16 +15,37 @@ type Indicators struct {
 }
 
 type Results struct {
-	MDNS         []MDNSResult  `json:"mdns"`
-	ARPResults   []ARPResult   `json:"arp"`
-	USBFindings  []USBFinding  `json:"usb"`
-	HTTPFindings []HTTPFinding `json:"http"`
+	MDNS         []MDNSResult        `json:"mdns"`
+	ARPResults   []ARPResult         `json:"arp"`
+	USBFindings  []USBFinding        `json:"usb"`
+	HTTPFindings []HTTPFinding       `json:"http"`
+	Displays     []DisplayFinding    `json:"display"`
+	Input        []InputFinding      `json:"input"`
+	USBHistory   []RegistryUSBDevice `json:"usb_history"`
+	Neighbors    ARPDiscovery        `json:"neighbors"`
+	DNSSD        []DNSSDFinding      `json:"dnssd"`
+	Services     []DNSSDService      `json:"dnssd_services"`
+	Heard        []HeardRecord       `json:"heard"`
+	SSDP         []SSDPFinding       `json:"ssdp"`
+	SSDPDevices  []SSDPDevice        `json:"ssdp_devices"`
 }
 
 func main() {
 	configPath := flag.String("i", "indicators.yaml", "path to the indicators yaml file")
 	debugF := flag.Bool("d", false, "turn on debug (verbose) logging")
 	noMdnsListen := flag.Bool("m", false, "if set, no mdns ports will be opened and only subprocesses will be used")
+	kernelLogsF := flag.String("k", "", "comma separated kernel log files (dmesg, kern.log, journalctl export) to search for past USB devices, defaults to the live logs on linux")
+	watchF := flag.Bool("w", false, "watch for USB devices being plugged in and check them right away (linux only)")
+	setupAPIF := flag.String("setupapi", "", "comma separated setupapi.dev.log files to search for past USB devices, defaults to the live logs on windows")
+	sweepF := flag.Bool("sweep", false, "send ARP requests to every host of the local IPv4 subnets before checking the neighbor table (linux only, needs CAP_NET_RAW)")
+	sweepRateF := flag.Int("sweep-rate", 50, "ARP sweep requests per second")
+	sweepMaxF := flag.Int("sweep-max-hosts", 1024, "skip subnets with more hosts than this in the ARP sweep")
+	sweep6F := flag.Bool("sweep6", false, "send an ICMPv6 echo to ff02::1 and neighbor solicitations on every interface to find IPv6 link-local devices (needs root)")
+	ouiF := flag.String("oui", "", "comma separated IEEE registry CSV files (oui.csv, mam.csv, oui36.csv) to look up MAC organizations in, on top of the bundled list")
+	mdnsIfaceF := flag.String("mdns-iface", "", "comma separated interfaces to send mDNS queries and SSDP searches on, defaults to every up multicast interface")
+	listenF := flag.Duration("listen", 0, "passive mode: only listen for mDNS, LLMNR and NBNS names for this long (ex: 5m) and match the mdns indicators against them, nothing is sent")
+	leasesF := flag.String("leases", "", "comma separated DHCP server lease files (dnsmasq, ISC dhcpd or macOS bootpd) to take neighbor hostnames from")
+	hiveF := flag.String("hive", "", "offline mode: path to a windows SYSTEM registry hive to search for past USB devices, no live checks are run")
 	flag.Parse()
 
 	// This is a placeholder for the main function.
@@ -38,6 +60,73 @@ func main() {
 	// load the indicators.yaml file
 	config := GetConfig(*configPath)
 
+	// watch mode replaces the one-shot scan
+	if *watchF {
+		err := watchUSB(config.USB)
+		log.Fatal().Err(err).Msg("USB watch failed")
+	}
+
+	setupAPILogs := []string{}
+	if *setupAPIF != "" {
+		setupAPILogs = strings.Split(*setupAPIF, ",")
+	}
+
+	// offline mode only looks at the collected hive (and setupapi logs)
+	if *hiveF != "" {
+		history, findings, err := checkRegistryHive(config.USB, *hiveF)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", *hiveF).Msg("Failed to read registry hive")
+		}
+		if len(setupAPILogs) > 0 {
+			findings = append(findings, checkSetupAPIHistory(config.USB, setupAPILogs)...)
+		}
+		r := Results{USBHistory: history, USBFindings: findings}
+		for _, finding := range findings {
+			log.Info().
+				Str("vendor", finding.Vendor).
+				Str("manufacturer", finding.Manufacturer).
+				Str("confidence", finding.Confidence).
+				Str("first_seen", finding.FirstSeen).
+				Str("last_seen", finding.LastSeen).
+				Msg("USB history result")
+		}
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to marshal results as json")
+		}
+		fmt.Print(string(b))
+		return
+	}
+
+	mdnsIfaces := []string{}
+	if *mdnsIfaceF != "" {
+		mdnsIfaces = strings.Split(*mdnsIfaceF, ",")
+	}
+
+	// passive mode only reports what was announced on the network
+	if *listenF > 0 {
+		heard, err := listenPassive(*listenF, mdnsIfaces)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Passive listening failed")
+		}
+		r := Results{Heard: heard, MDNS: checkHostnames(config.Network.MDNS, heardNames(heard))}
+		for _, result := range r.MDNS {
+			log.Info().
+				Str("domain", result.Domain).
+				Str("vendor", result.Vendor).
+				Str("source", result.Source).
+				Str("method", result.Method).
+				Str("pattern", result.Pattern).
+				Msg("mDNS discovery result")
+		}
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to marshal results as json")
+		}
+		fmt.Print(string(b))
+		return
+	}
+
 	// create the output obj
 	r := Results{}
 
@@ -45,7 +134,7 @@ func main() {
 	var mdns []MDNSResult
 	var err error
 	if !*noMdnsListen {
-		mdns, err = resolveMDNSNames(config.Network.MDNS)
+		mdns, err = resolveMDNSNames(config.Network.MDNS, mdnsIfaces)
 	} else {
 		mdns, err = mDNSDiscoverySubp(config.Network.MDNS)
 	}
@@ -54,19 +143,98 @@ func main() {
 	} else {
 		r.MDNS = mdns
 		for _, result := range mdns {
-			log.Info().Str("domain", result.Domain).Msg("mDNS discovery result")
+			log.Info().
+				Str("domain", result.Domain).
+				Str("vendor", result.Vendor).
+				Str("source", result.Source).
+				Str("method", result.Method).
+				Str("interface", result.Interface).
+				Str("family", result.Family).
+				Msg("mDNS discovery result")
+		}
+	}
+	// browse the advertised services, renamed devices still announce them
+	if !*noMdnsListen {
+		services, err := browseDNSSD(dnssdIndicatorTypes(config.Network.DNSSD), mdnsIfaces)
+		if err != nil {
+			log.Error().Err(err).Msg("DNS-SD browsing failed")
+		} else {
+			r.Services = services
+			r.DNSSD = checkDNSSD(config.Network.DNSSD, services)
+			for _, finding := range r.DNSSD {
+				log.Info().
+					Str("vendor", finding.Vendor).
+					Str("type", finding.Type).
+					Str("value", finding.Value).
+					Str("confidence", finding.Confidence).
+					Msg("DNS-SD discovery result")
+			}
+			// renamed instances and targets can still match a hostname pattern
+			r.MDNS = append(r.MDNS, checkHostnames(config.Network.MDNS, dnssdNames(services))...)
 		}
 	}
+
+	// UPnP descriptions name the manufacturer and model
+	devices, err := searchSSDP(mdnsIfaces)
+	if err != nil {
+		log.Error().Err(err).Msg("SSDP discovery failed")
+	} else {
+		r.SSDPDevices = devices
+		r.SSDP = checkSSDP(config.Network.SSDP, devices)
+		for _, finding := range r.SSDP {
+			log.Info().
+				Str("vendor", finding.Vendor).
+				Str("field", finding.Field).
+				Str("value", finding.Value).
+				Str("confidence", finding.Confidence).
+				Msg("SSDP discovery result")
+		}
+	}
+
 	// do the arp check
 	arp_results, err := arpDiscovery()
 	if err != nil {
 		log.Error().Err(err).Msg("ARP discovery failed")
-	} else {
-		matched_macs := checkARPMacs(config.Network.MACAddresses, arp_results.MACs)
-		r.ARPResults = matched_macs
-		for _, mac := range matched_macs {
-			log.Info().Str("mac", mac.MAC).Msg("Matched MAC address")
+	}
+	// quiet hosts aren't in the neighbor table until we talk to them
+	if *sweepF {
+		swept, err := arpSweep(*sweepRateF, *sweepMaxF)
+		if err != nil {
+			log.Error().Err(err).Msg("ARP sweep failed")
+		}
+		arp_results = mergeNeighbors(arp_results, swept)
+	}
+	// KVMs often still answer on IPv6 link-local when IPv4 is segmented
+	if *sweep6F {
+		swept, err := ndSweep()
+		if err != nil {
+			log.Error().Err(err).Msg("IPv6 neighbor discovery sweep failed")
 		}
+		arp_results = mergeNeighbors(arp_results, swept)
+	}
+	ouiFiles := []string{}
+	if *ouiF != "" {
+		ouiFiles = strings.Split(*ouiF, ",")
+	}
+	arp_results.annotate(loadOUIRegistry(ouiFiles))
+	// the neighbor table only has addresses, their names can give a KVM away
+	// and make the http findings readable
+	hostnames := reverseLookup(arp_results.IPs(), mdnsIfaces, !*noMdnsListen)
+	if *leasesF != "" {
+		hostnames = append(hostnames, readDHCPLeases(strings.Split(*leasesF, ","))...)
+	}
+	arp_results.addHostnames(hostnames)
+	r.MDNS = append(r.MDNS, checkHostnames(config.Network.MDNS, hostnames)...)
+	r.Neighbors = arp_results
+	matched_macs := checkARPMacs(config.Network.MACAddresses, arp_results)
+	r.ARPResults = matched_macs
+	for _, mac := range matched_macs {
+		log.Info().
+			Str("mac", mac.MAC).
+			Str("vendor", mac.Vendor).
+			Str("organization", mac.Organization).
+			Str("confidence", mac.Confidence).
+			Msg("Matched MAC address")
 	}
 
 	// perform usb discovery
@@ -80,20 +248,70 @@ func main() {
 			Msg("USB discovery result")
 	}
 
+	// look for usb devices that were connected in the past
+	kernelLogs := []string{}
+	if *kernelLogsF != "" {
+		kernelLogs = strings.Split(*kernelLogsF, ",")
+	}
+	historical_findings := checkKernelUSBHistory(config.USB, kernelLogs)
+	historical_findings = append(historical_findings, checkSetupAPIHistory(config.USB, setupAPILogs)...)
+	r.USBFindings = append(r.USBFindings, historical_findings...)
+	for _, finding := range historical_findings {
+		log.Info().
+			Str("vendor", finding.Vendor).
+			Str("manufacturer", finding.Manufacturer).
+			Str("confidence", finding.Confidence).
+			Str("first_seen", finding.FirstSeen).
+			Str("last_seen", finding.LastSeen).
+			Msg("USB history result")
+	}
+
+	// perform display (EDID) discovery
+	display_findings := checkDisplays(config.Display)
+	r.Displays = display_findings
+	for _, finding := range display_findings {
+		log.Info().
+			Str("vendor", finding.Vendor).
+			Str("name", finding.Name).
+			Str("confidence", finding.Confidence).
+			Msg("Display discovery result")
+	}
+
+	// perform input device (HID) discovery
+	input_findings := checkInputDevices(config.HID)
+	r.Input = input_findings
+	for _, finding := range input_findings {
+		log.Info().
+			Str("vendor", finding.Vendor).
+			Str("type", finding.Type).
+			Str("confidence", finding.Confidence).
+			Msg("Input device discovery result")
+	}
+
 	// perform http checks
 	checkDomains := []string{}
+	checkIPs := arp_results.IPs()
 	for _, m := range mdns {
-		checkDomains = append(checkDomains, m.Domain)
+		// a domain can answer on several interfaces and families
+		if !slices.Contains(checkDomains, m.Domain) {
+			checkDomains = append(checkDomains, m.Domain)
+		}
 		// remove IPs from IP list if the domain exists
 		for _, ip := range m.IPv4s {
 			ips := ip.String()
-			ipIndex := slices.Index(arp_results.IPs, ips)
+			ipIndex := slices.Index(checkIPs, ips)
+			if ipIndex != -1 {
+				checkIPs = append(checkIPs[:ipIndex], checkIPs[ipIndex+1:]...)
+			}
+		}
+		for _, ip := range m.IPv6s {
+			ipIndex := slices.Index(checkIPs, ip.String())
 			if ipIndex != -1 {
-				arp_results.IPs = append(arp_results.IPs[:ipIndex], arp_results.IPs[ipIndex+1:]...)
+				checkIPs = append(checkIPs[:ipIndex], checkIPs[ipIndex+1:]...)
 			}
 		}
 	}
-	http_findings := httpQueries(arp_results.IPs, checkDomains, config.HTTP)
+	http_findings, http_names := httpQueries(checkIPs, checkDomains, config.HTTP, hostnamesByIP(hostnames))
 	r.HTTPFindings = http_findings
 	for _, http_finding := range http_findings {
 		log.Info().
@@ -102,8 +320,11 @@ func main() {
 			Str("type", http_finding.Type).
 			Str("value", http_finding.Value).
 			Str("hostname", http_finding.Hostname).
+			Str("ip", http_finding.IP).
 			Msg("http discovery result")
 	}
+	// certificate names and redirect targets give the real hostname away
+	r.MDNS = append(r.MDNS, checkHostnames(config.Network.MDNS, http_names)...)
 
 	// format the output and write it as json
 	b, err := json.MarshalIndent(r, "", "  ")
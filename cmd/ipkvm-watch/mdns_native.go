@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// mdnsQueryTime is how long the native resolver listens for answers, the
+// query is repeated every mdnsQueryInterval until then
+var (
+	mdnsQueryTime     = 3 * time.Second
+	mdnsQueryInterval = time.Second
+)
+
+var (
+	mdnsGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// mdnsRecord is an A or AAAA record from an mDNS response
+type mdnsRecord struct {
+	Name string // lowercase without the trailing dot
+	Addr netip.Addr
+	TTL  uint32
+}
+
+// mdnsQuestions asks for every record type given of every name
+func mdnsQuestions(names []string, types ...dnsmessage.Type) ([]dnsmessage.Question, error) {
+	questions := []dnsmessage.Question{}
+	for _, name := range names {
+		n, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+		if err != nil {
+			return nil, fmt.Errorf("bad mDNS name %q: %w", name, err)
+		}
+		for _, t := range types {
+			questions = append(questions, dnsmessage.Question{Name: n, Type: t, Class: dnsmessage.ClassINET})
+		}
+	}
+	return questions, nil
+}
+
+// buildMDNSQuery puts every question in one message
+func buildMDNSQuery(questions []dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// mdnsName writes a record name the way the indicators are written,
+// lowercase without the trailing dot
+func mdnsName(name dnsmessage.Name) string {
+	return strings.ToLower(strings.TrimSuffix(name.String(), "."))
+}
+
+// mdnsResources returns the answer and additional records of a response,
+// queries (ours included, they loop back) have none
+func mdnsResources(msg dnsmessage.Message) []dnsmessage.Resource {
+	if !msg.Response {
+		return nil
+	}
+	return slices.Concat(msg.Answers, msg.Additionals)
+}
+
+// mdnsAddrRecords returns the A and AAAA records of a response
+func mdnsAddrRecords(msg dnsmessage.Message) []mdnsRecord {
+	records := []mdnsRecord{}
+	for _, rr := range mdnsResources(msg) {
+		rec := mdnsRecord{Name: mdnsName(rr.Header.Name), TTL: rr.Header.TTL}
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			rec.Addr = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			rec.Addr = netip.AddrFrom16(body.AAAA)
+		default:
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// mdnsPacket is a response with where it came from
+type mdnsPacket struct {
+	msg       dnsmessage.Message
+	Interface string
+	Family    string
+	Source    string
+}
+
+// mdnsConn is the multicast socket of one address family (mDNS or LLMNR),
+// joined on every interface the queries go out on
+type mdnsConn interface {
+	family() string
+	send(b []byte, ifi net.Interface) error
+	receive(b []byte) (n int, ifIndex int, src net.Addr, err error)
+	setDeadline(t time.Time) error
+	Close() error
+}
+
+type mdnsConn4 struct {
+	c     net.PacketConn
+	p     *ipv4.PacketConn
+	group *net.UDPAddr
+}
+
+func (m mdnsConn4) family() string { return "ipv4" }
+
+func (m mdnsConn4) send(b []byte, ifi net.Interface) error {
+	if err := m.p.SetMulticastInterface(&ifi); err != nil {
+		return err
+	}
+	_, err := m.p.WriteTo(b, nil, m.group)
+	return err
+}
+
+func (m mdnsConn4) receive(b []byte) (int, int, net.Addr, error) {
+	n, cm, src, err := m.p.ReadFrom(b)
+	if cm == nil {
+		return n, 0, src, err
+	}
+	return n, cm.IfIndex, src, err
+}
+
+func (m mdnsConn4) setDeadline(t time.Time) error { return m.p.SetReadDeadline(t) }
+func (m mdnsConn4) Close() error                  { return m.c.Close() }
+
+type mdnsConn6 struct {
+	c     net.PacketConn
+	p     *ipv6.PacketConn
+	group *net.UDPAddr
+}
+
+func (m mdnsConn6) family() string { return "ipv6" }
+
+func (m mdnsConn6) send(b []byte, ifi net.Interface) error {
+	_, err := m.p.WriteTo(b, &ipv6.ControlMessage{IfIndex: ifi.Index}, m.group)
+	return err
+}
+
+func (m mdnsConn6) receive(b []byte) (int, int, net.Addr, error) {
+	n, cm, src, err := m.p.ReadFrom(b)
+	if cm == nil {
+		return n, 0, src, err
+	}
+	return n, cm.IfIndex, src, err
+}
+
+func (m mdnsConn6) setDeadline(t time.Time) error { return m.p.SetReadDeadline(t) }
+func (m mdnsConn6) Close() error                  { return m.c.Close() }
+
+// listenMDNS opens the mDNS port of a family and joins the group on every
+// interface it can, it returns the interfaces that were joined
+func listenMDNS(family string, ifaces []net.Interface) (mdnsConn, []net.Interface, error) {
+	if family == "ipv4" {
+		return listenGroup(mdnsGroupIPv4, ifaces)
+	}
+	return listenGroup(mdnsGroupIPv6, ifaces)
+}
+
+// listenGroup binds the port of a multicast group and joins it on every
+// interface it can, it returns the interfaces that were joined
+func listenGroup(group *net.UDPAddr, ifaces []net.Interface) (mdnsConn, []net.Interface, error) {
+	joined := []net.Interface{}
+	if group.IP.To4() != nil {
+		c, err := net.ListenUDP("udp4", group)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := ipv4.NewPacketConn(c)
+		if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+			log.Debug().Err(err).Str("group", group.String()).Msg("No interface control messages on multicast socket")
+		}
+		for _, ifi := range ifaces {
+			if err := p.JoinGroup(&ifi, group); err != nil {
+				log.Debug().Err(err).Str("interface", ifi.Name).Str("group", group.String()).Msg("Failed to join multicast group")
+				continue
+			}
+			joined = append(joined, ifi)
+		}
+		return mdnsConn4{c: c, p: p, group: group}, joined, nil
+	}
+	c, err := net.ListenUDP("udp6", group)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := ipv6.NewPacketConn(c)
+	if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		log.Debug().Err(err).Str("group", group.String()).Msg("No interface control messages on multicast socket")
+	}
+	for _, ifi := range ifaces {
+		if err := p.JoinGroup(&ifi, group); err != nil {
+			log.Debug().Err(err).Str("interface", ifi.Name).Str("group", group.String()).Msg("Failed to join multicast group")
+			continue
+		}
+		joined = append(joined, ifi)
+	}
+	return mdnsConn6{c: c, p: p, group: group}, joined, nil
+}
+
+// mdnsInterfaces lists the up, multicast capable, non loopback interfaces,
+// only the named ones when names are given
+func mdnsInterfaces(names []string) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	ifaces := []net.Interface{}
+	for _, ifi := range all {
+		if len(names) > 0 && !slices.Contains(names, ifi.Name) {
+			continue
+		}
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			if len(names) > 0 {
+				log.Warn().Str("interface", ifi.Name).Msg("Interface is down or can't multicast, not sending mDNS queries on it")
+			}
+			continue
+		}
+		ifaces = append(ifaces, ifi)
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(all, func(ifi net.Interface) bool { return ifi.Name == name }) {
+			return nil, fmt.Errorf("no interface named %s", name)
+		}
+	}
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("no interfaces to send mDNS queries on")
+	}
+	return ifaces, nil
+}
+
+// mdnsQuery sends the questions on every interface over IPv4 and IPv6 until
+// mdnsQueryTime has passed and hands every response to handle, one at a time
+func mdnsQuery(questions []dnsmessage.Question, ifaces []net.Interface, handle func(mdnsPacket)) error {
+	query, err := buildMDNSQuery(questions)
+	if err != nil {
+		return err
+	}
+	ifaceNamesByIndex := map[int]string{}
+	for _, ifi := range ifaces {
+		ifaceNamesByIndex[ifi.Index] = ifi.Name
+	}
+
+	type mdnsSocket struct {
+		conn   mdnsConn
+		joined []net.Interface
+	}
+	sockets := []mdnsSocket{}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		conn, joined, err := listenMDNS(family, ifaces)
+		if err != nil {
+			log.Warn().Err(err).Str("family", family).Msg("Failed to open mDNS socket")
+			continue
+		}
+		defer conn.Close()
+		sockets = append(sockets, mdnsSocket{conn: conn, joined: joined})
+	}
+	if len(sockets) == 0 {
+		return fmt.Errorf("failed to open an IPv4 or IPv6 mDNS socket")
+	}
+
+	var mu sync.Mutex
+	deadline := time.Now().Add(mdnsQueryTime)
+	var wg sync.WaitGroup
+	for _, s := range sockets {
+		s.conn.setDeadline(deadline)
+		wg.Add(1)
+		go func(conn mdnsConn) {
+			defer wg.Done()
+			buf := make([]byte, 9000)
+			for {
+				n, ifIndex, src, err := conn.receive(buf)
+				if err != nil {
+					if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+						log.Error().Err(err).Str("family", conn.family()).Msg("Failed to read mDNS answers")
+					}
+					return
+				}
+				pkt := mdnsPacket{Interface: ifaceNamesByIndex[ifIndex], Family: conn.family()}
+				if err := pkt.msg.Unpack(buf[:n]); err != nil {
+					log.Debug().Err(err).Str("src", src.String()).Msg("Skipping bad mDNS packet")
+					continue
+				}
+				if udp, ok := src.(*net.UDPAddr); ok {
+					pkt.Source = udp.IP.String()
+					if pkt.Interface == "" {
+						pkt.Interface = udp.Zone
+					}
+				}
+				mu.Lock()
+				handle(pkt)
+				mu.Unlock()
+			}
+		}(s.conn)
+	}
+
+	// repeat the query until the deadline, answers can get lost on busy links
+	for time.Now().Before(deadline) {
+		for _, s := range sockets {
+			for _, ifi := range s.joined {
+				if err := s.conn.send(query, ifi); err != nil {
+					log.Debug().Err(err).Str("interface", ifi.Name).Str("family", s.conn.family()).Msg("Failed to send mDNS query")
+				}
+			}
+		}
+		time.Sleep(min(mdnsQueryInterval, time.Until(deadline)))
+	}
+	wg.Wait()
+	return nil
+}
+
+// withLinkZone gives link-local addresses the interface they were heard
+// on, they're only reachable through it
+func withLinkZone(addr netip.Addr, iface string) netip.Addr {
+	if addr.Is6() && addr.IsLinkLocalUnicast() && iface != "" {
+		return addr.WithZone(iface)
+	}
+	return addr
+}
+
+// resolveMDNSNames multicasts one query for every indicator domain on every
+// interface over IPv4 and IPv6, and collects every A/AAAA answer with the
+// responder, interface and family it came from. ifaceNames limits the
+// interfaces used.
+func resolveMDNSNames(mdns_indicators map[string][]string, ifaceNames []string) ([]MDNSResult, error) {
+	found_domains := []MDNSResult{}
+	names := []string{}
+	for _, domains := range mdns_indicators {
+		for _, domain := range domains {
+			// globs and regexes only match what the passive listener hears
+			if isNamePattern(domain) {
+				continue
+			}
+			name := canonicalName(domain)
+			if name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return found_domains, nil
+	}
+	questions, err := mdnsQuestions(names, dnsmessage.TypeA, dnsmessage.TypeAAAA)
+	if err != nil {
+		return found_domains, err
+	}
+	ifaces, err := mdnsInterfaces(ifaceNames)
+	if err != nil {
+		return found_domains, err
+	}
+
+	// answers are kept per name, interface and family
+	type answerKey struct {
+		name, iface, family string
+	}
+	order := []answerKey{}
+	answers := map[answerKey]*MDNSResult{}
+	err = mdnsQuery(questions, ifaces, func(pkt mdnsPacket) {
+		for _, rec := range mdnsAddrRecords(pkt.msg) {
+			if !slices.Contains(names, rec.Name) {
+				continue
+			}
+			addr := withLinkZone(rec.Addr, pkt.Interface)
+			key := answerKey{rec.Name, pkt.Interface, pkt.Family}
+			a, ok := answers[key]
+			if !ok {
+				a = &MDNSResult{
+					Domain:    rec.Name,
+					IPv4s:     []net.IP{},
+					IPv6s:     []*net.IPAddr{},
+					Source:    pkt.Source,
+					Method:    "native",
+					Interface: pkt.Interface,
+					Family:    pkt.Family,
+				}
+				answers[key] = a
+				order = append(order, key)
+			}
+			a.addAddr(addr)
+			a.addTTL(rec.TTL)
+			log.Debug().
+				Str("hostname", rec.Name).
+				Str("addr", addr.String()).
+				Str("src", pkt.Source).
+				Str("interface", pkt.Interface).
+				Str("family", pkt.Family).
+				Msg("mDNS answer")
+		}
+	})
+	if err != nil {
+		return found_domains, err
+	}
+
+	for vendor, domains := range mdns_indicators {
+		for _, domain := range domains {
+			if isNamePattern(domain) {
+				continue
+			}
+			name := canonicalName(domain)
+			resolved := false
+			for _, key := range order {
+				if key.name != name {
+					continue
+				}
+				resolved = true
+				mdns_result := *answers[key]
+				mdns_result.Domain = domain
+				mdns_result.Vendor = vendor
+				mdns_result.Pattern = domain
+				log.Info().
+					Str("hostname", domain).
+					Str("src", mdns_result.Source).
+					Str("interface", mdns_result.Interface).
+					Str("family", mdns_result.Family).
+					Uint32("ttl", mdns_result.TTL).
+					Msg("mDNS query result")
+				found_domains = append(found_domains, mdns_result)
+			}
+			if !resolved {
+				log.Debug().Str("hostname", domain).Msg("No mDNS answer")
+			}
+		}
+	}
+	return found_domains, nil
+}
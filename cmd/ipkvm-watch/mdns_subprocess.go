@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// digServer matches the server line of dig's stats, for multicast queries
+// it names the responder, ex: ";; SERVER: 192.168.8.1#5353(224.0.0.251) (UDP)"
+var digServer = regexp.MustCompile(`^;; SERVER: ([^#\s]+)#`)
+
+// digUnexpectedSource matches the warning older dig versions print when the
+// answer to a multicast query comes from a unicast address
+// ex: ";; reply from unexpected source: 192.168.8.1#5353, expected 224.0.0.251#5353"
+var digUnexpectedSource = regexp.MustCompile(`reply from unexpected source: ([^#\s]+)#`)
+
+// parseDig reads the A and AAAA records for domain from the answer and
+// additional sections of dig output
+//
+//	;; ANSWER SECTION:
+//	glkvm.local.		120	IN	A	192.168.8.1
+//
+//	;; ADDITIONAL SECTION:
+//	glkvm.local.		120	IN	AAAA	fe80::9683:c4ff:feae:ac2a
+func parseDig(digOutput string, domain string) MDNSResult {
+	r := MDNSResult{Domain: domain, IPv4s: []net.IP{}, IPv6s: []*net.IPAddr{}}
+	name := strings.ToLower(strings.TrimSuffix(domain, ".")) + "."
+	inRecords := false
+	for _, line := range strings.Split(digOutput, "\n") {
+		line = strings.TrimSpace(strings.TrimRight(line, "\r"))
+		if line == "" {
+			inRecords = false
+			continue
+		}
+		if m := digUnexpectedSource.FindStringSubmatch(line); m != nil {
+			r.Source = m[1]
+			continue
+		}
+		if m := digServer.FindStringSubmatch(line); m != nil {
+			if addr, err := netip.ParseAddr(m[1]); err == nil && !addr.IsMulticast() && r.Source == "" {
+				r.Source = m[1]
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ";; ") && strings.HasSuffix(line, " SECTION:") {
+			inRecords = line == ";; ANSWER SECTION:" || line == ";; ADDITIONAL SECTION:"
+			continue
+		}
+		if !inRecords || strings.HasPrefix(line, ";") {
+			continue
+		}
+		// name ttl class type data
+		fields := strings.Fields(line)
+		if len(fields) < 5 || strings.ToLower(fields[0]) != name || (fields[3] != "A" && fields[3] != "AAAA") {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[4])
+		if err != nil {
+			continue
+		}
+		r.addAddr(addr)
+		if ttl, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
+			r.addTTL(uint32(ttl))
+		}
+	}
+	return r
+}
+
+// parseDscacheutil reads the addresses from `dscacheutil -q host -a name`
+// output, it has no TTL or source
+//
+//	name: glkvm.local
+//	ipv6_address: fe80:4::9683:c4ff:feae:ac2a
+//	ip_address: 192.168.8.1
+func parseDscacheutil(dscacheutilOutput string, domain string) MDNSResult {
+	r := MDNSResult{Domain: domain, IPv4s: []net.IP{}, IPv6s: []*net.IPAddr{}}
+	for _, line := range strings.Split(dscacheutilOutput, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok || (key != "ip_address" && key != "ipv6_address") {
+			continue
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		r.addAddr(kameScope(addr))
+	}
+	return r
+}
+
+// kameScope moves the interface index macOS embeds in the second group of
+// link-local addresses (fe80:4::1) into the zone (fe80::1%en0)
+func kameScope(addr netip.Addr) netip.Addr {
+	if !addr.Is6() || !addr.IsLinkLocalUnicast() || addr.Zone() != "" {
+		return addr
+	}
+	b := addr.As16()
+	index := binary.BigEndian.Uint16(b[2:4])
+	if index == 0 {
+		return addr
+	}
+	b[2], b[3] = 0, 0
+	zone := strconv.Itoa(int(index))
+	if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+		zone = ifi.Name
+	}
+	return netip.AddrFrom16(b).WithZone(zone)
+}
+
+// parseResolveDnsName reads the table Resolve-DnsName prints, it has no
+// source
+//
+//	Name                                           Type   TTL   Section    IPAddress
+//	----                                           ----   ---   -------    ---------
+//	glkvm.local                                    AAAA   120   Answer     fe80::9683:c4ff:feae:ac2a
+//	glkvm.local                                    A      120   Answer     192.168.8.1
+func parseResolveDnsName(resolveOutput string, domain string) MDNSResult {
+	r := MDNSResult{Domain: domain, IPv4s: []net.IP{}, IPv6s: []*net.IPAddr{}}
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, line := range strings.Split(resolveOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || strings.ToLower(strings.TrimSuffix(fields[0], ".")) != name || (fields[1] != "A" && fields[1] != "AAAA") {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[4])
+		if err != nil {
+			continue
+		}
+		r.addAddr(addr)
+		if ttl, err := strconv.ParseUint(fields[2], 10, 32); err == nil {
+			r.addTTL(uint32(ttl))
+		}
+	}
+	return r
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMDNSSubprocessParsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(string, string) MDNSResult
+		path   string
+		domain string
+		ipv4s  []string
+		ipv6s  []string
+		source string
+		ttl    uint32
+	}{
+		{"dig", parseDig, "testdata/mdns/dig", "glkvm.local",
+			[]string{"192.168.8.1"}, []string{"fe80::9683:c4ff:feae:ac2a"}, "192.168.8.1", 10},
+		{"dig unexpected source", parseDig, "testdata/mdns/dig_unexpected_source", "glkvm.local.",
+			[]string{"192.168.8.1"}, []string{}, "192.168.8.1", 120},
+		{"dig no answer", parseDig, "testdata/mdns/dig_no_answer", "pikvm.local",
+			[]string{}, []string{}, "", 0},
+		{"dscacheutil", parseDscacheutil, "testdata/mdns/dscacheutil", "glkvm.local",
+			[]string{"192.168.8.1"}, []string{"fe80::9683:c4ff:feae:ac2a", "fd00::9683:c4ff:feae:ac2a"}, "", 0},
+		{"Resolve-DnsName", parseResolveDnsName, "testdata/mdns/resolve_dnsname", "glkvm.local",
+			[]string{"192.168.8.1"}, []string{"fe80::9683:c4ff:feae:ac2a"}, "", 10},
+	}
+	for _, tt := range tests {
+		r := tt.parse(readFixture(t, tt.path), tt.domain)
+		ipv4s := []string{}
+		for _, ip := range r.IPv4s {
+			ipv4s = append(ipv4s, ip.String())
+		}
+		ipv6s := []string{}
+		for _, ip := range r.IPv6s {
+			ipv6s = append(ipv6s, ip.IP.String())
+		}
+		if !slices.Equal(ipv4s, tt.ipv4s) || !slices.Equal(ipv6s, tt.ipv6s) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, ipv4s, ipv6s, tt.ipv4s, tt.ipv6s)
+		}
+		if r.Source != tt.source || r.TTL != tt.ttl {
+			t.Errorf("%s: got source %q ttl %d, want %q %d", tt.name, r.Source, r.TTL, tt.source, tt.ttl)
+		}
+	}
+}
+
+func TestKameScope(t *testing.T) {
+	r := parseDscacheutil("ipv6_address: fe80:4::9683:c4ff:feae:ac2a\n", "glkvm.local")
+	if len(r.IPv6s) != 1 {
+		t.Fatalf("got %v", r.IPv6s)
+	}
+	// the zone is the interface name when index 4 exists here, the index otherwise
+	if ip := r.IPv6s[0]; ip.IP.String() != "fe80::9683:c4ff:feae:ac2a" || ip.Zone == "" {
+		t.Errorf("got %s zone %q", ip.IP, ip.Zone)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// namePattern is a hostname indicator: a plain name, a glob with *, ? or
+// [...] (ex: '*kvm*.local') or a regular expression written as re:<expr>
+// (ex: 're:^pi-?kvm[0-9]*\.local$'). Names are compared lowercase without
+// the trailing dot.
+type namePattern struct {
+	raw  string
+	glob string
+	re   *regexp.Regexp
+}
+
+func parseNamePattern(pattern string) (namePattern, error) {
+	p := namePattern{raw: pattern}
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return p, fmt.Errorf("bad name regex %q: %w", pattern, err)
+		}
+		p.re = re
+		return p, nil
+	}
+	if isNamePattern(pattern) {
+		p.glob = canonicalName(pattern)
+		if _, err := path.Match(p.glob, ""); err != nil {
+			return p, fmt.Errorf("bad name glob %q: %w", pattern, err)
+		}
+	}
+	return p, nil
+}
+
+// isNamePattern tells globs and regexes from plain names, only plain names
+// can be queried for
+func isNamePattern(name string) bool {
+	return strings.HasPrefix(name, "re:") || strings.ContainsAny(name, "*?[")
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (p namePattern) match(name string) bool {
+	name = canonicalName(name)
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.glob != "":
+		ok, _ := path.Match(p.glob, name)
+		return ok
+	}
+	return name == canonicalName(p.raw)
+}
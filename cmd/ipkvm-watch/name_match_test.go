@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"glkvm.local", "glkvm.local", true},
+		{"glkvm.local", "GLKVM.local.", true},
+		{"glkvm.local", "glkvm.local.lan", false},
+		{"glkvm.local.", "glkvm.local", true},
+		{"*kvm*.local", "pikvm.local", true},
+		{"*kvm*.local", "My-KVM-2.local.", true},
+		{"*kvm*.local", "pikvm.lan", false},
+		{"*kvm*.local", "printer.local", false},
+		{"kvm-?.local", "kvm-4.local", true},
+		{"kvm-[0-9].local", "kvm-x.local", false},
+		{`re:^pi-?kvm[0-9]*\.local$`, "pikvm.local", true},
+		{`re:^pi-?kvm[0-9]*\.local$`, "PI-KVM42.local.", true},
+		{`re:^pi-?kvm[0-9]*\.local$`, "pi-kvm-v4.local", false},
+		{`re:^pi-?kvm[0-9]*\.local$`, "mypikvm.local", false},
+	}
+	for _, tt := range tests {
+		p, err := parseNamePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+		if got := p.match(tt.name); got != tt.match {
+			t.Errorf("%q match %q = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestParseNamePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"kvm[.local", "re:pi(kvm"} {
+		if _, err := parseNamePattern(pattern); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
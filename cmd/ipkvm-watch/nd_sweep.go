@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// ndSweepWait is how long to listen after the echo and after the solicitations
+var ndSweepWait = 2 * time.Second
+
+// ICMPv6 option types from RFC 4861
+const (
+	ndOptSourceLinkAddr = 1
+	ndOptTargetLinkAddr = 2
+	icmpv6Protocol      = 58
+)
+
+var allNodes = net.ParseIP("ff02::1")
+
+// ndEchoID marks our echo requests ("KV") so other tools' pings aren't
+// taken for answers
+const ndEchoID = 0x4b56
+
+// isNDEchoReply tells replies to our echo from everything else the socket
+// sees, the raw socket gets the replies to every ping on the host
+func isNDEchoReply(msg *icmp.Message) bool {
+	echo, ok := msg.Body.(*icmp.Echo)
+	return ok && msg.Type == ipv6.ICMPTypeEchoReply && echo.ID == ndEchoID
+}
+
+// eui64MAC derives the MAC from an address with a modified EUI-64 interface
+// id (xx:xx:xx:ff:fe:xx:xx:xx with the universal/local bit flipped)
+func eui64MAC(ip netip.Addr) (net.HardwareAddr, bool) {
+	if !ip.Is6() || ip.Is4In6() {
+		return nil, false
+	}
+	b := ip.As16()
+	if b[11] != 0xff || b[12] != 0xfe {
+		return nil, false
+	}
+	return net.HardwareAddr{b[8] ^ 0x02, b[9], b[10], b[13], b[14], b[15]}, true
+}
+
+// solicitedNode returns the solicited-node multicast group of an address
+func solicitedNode(ip netip.Addr) net.IP {
+	b := ip.As16()
+	group := net.ParseIP("ff02::1:ff00:0")
+	copy(group[13:], b[13:])
+	return group
+}
+
+// buildNeighborSolicitation asks who has target, with our MAC so the
+// answer can come straight back
+func buildNeighborSolicitation(target netip.Addr, srcMAC net.HardwareAddr) ([]byte, error) {
+	t := target.As16()
+	body := make([]byte, 4, 4+16+8)
+	body = append(body, t[:]...)
+	body = append(body, ndOptSourceLinkAddr, 1)
+	body = append(body, srcMAC...)
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: body},
+	}
+	// the kernel fills in the checksum on raw ICMPv6 sockets
+	return msg.Marshal(nil)
+}
+
+// parseNeighborAdvertisement returns the target and its link-layer address
+// from the body of a neighbor advertisement
+func parseNeighborAdvertisement(body []byte) (netip.Addr, net.HardwareAddr, bool) {
+	if len(body) < 20 {
+		return netip.Addr{}, nil, false
+	}
+	target, _ := netip.AddrFromSlice(body[4:20])
+	options := body[20:]
+	for len(options) >= 8 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			break
+		}
+		if options[0] == ndOptTargetLinkAddr && length >= 8 {
+			return target, net.HardwareAddr(bytes.Clone(options[2:8])), true
+		}
+		options = options[length:]
+	}
+	return target, nil, true
+}
+
+// ndSweep pings ff02::1 on every interface, then sends a neighbor
+// solicitation to each address that answered to get its MAC. Hosts that
+// don't answer the solicitation get the MAC from their EUI-64 address when
+// they use one. It needs root (a raw ICMPv6 socket).
+func ndSweep() (ARPDiscovery, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMPv6 socket: %w", err)
+	}
+	defer c.Close()
+	p := ipv6.NewPacketConn(c)
+	if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		log.Debug().Err(err).Msg("No interface control messages on ICMPv6 socket")
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeEchoReply)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err := p.SetICMPFilter(&filter); err != nil {
+		log.Debug().Err(err).Msg("Failed to set ICMPv6 filter")
+	}
+
+	// our own addresses answer the multicast echo too
+	local := map[netip.Addr]bool{}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				if ip, ok := netip.AddrFromSlice(ipnet.IP); ok {
+					local[ip.Unmap()] = true
+				}
+			}
+		}
+	}
+
+	type responder struct {
+		ip    netip.Addr
+		iface string
+	}
+	var mu sync.Mutex
+	order := []responder{}
+	macs := map[responder]net.HardwareAddr{}
+	seen := map[responder]bool{}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	// stop ends the receiver, it's deferred so every return waits for it
+	// before the socket is closed
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+	defer stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			p.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, cm, src, err := p.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					continue
+				}
+				log.Error().Err(err).Msg("Failed to read ICMPv6 replies")
+				return
+			}
+			srcAddr, ok := src.(*net.IPAddr)
+			if !ok {
+				continue
+			}
+			r := responder{iface: srcAddr.Zone}
+			if r.iface == "" && cm != nil {
+				if ifi, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
+					r.iface = ifi.Name
+				}
+			}
+			r.ip, _ = netip.AddrFromSlice(srcAddr.IP)
+			msg, err := icmp.ParseMessage(icmpv6Protocol, buf[:n])
+			if err != nil {
+				continue
+			}
+			var mac net.HardwareAddr
+			if msg.Type == ipv6.ICMPTypeEchoReply && !isNDEchoReply(msg) {
+				continue
+			}
+			if msg.Type == ipv6.ICMPTypeNeighborAdvertisement {
+				raw, ok := msg.Body.(*icmp.RawBody)
+				if !ok {
+					continue
+				}
+				target, targetMAC, ok := parseNeighborAdvertisement(raw.Data)
+				if !ok {
+					continue
+				}
+				r.ip, mac = target, targetMAC
+			}
+			if local[r.ip] {
+				continue
+			}
+			mu.Lock()
+			if !seen[r] {
+				seen[r] = true
+				order = append(order, r)
+			}
+			if mac != nil {
+				macs[r] = mac
+			}
+			mu.Unlock()
+		}
+	}()
+
+	echo, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{ID: ndEchoID, Seq: 1, Data: []byte("ipkvm-watch")},
+	}).Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	swept := map[string]net.Interface{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		log.Info().Str("interface", iface.Name).Msg("Sending ICMPv6 echo to ff02::1")
+		cm := &ipv6.ControlMessage{IfIndex: iface.Index, HopLimit: 255}
+		if _, err := p.WriteTo(echo, cm, &net.IPAddr{IP: allNodes, Zone: iface.Name}); err != nil {
+			log.Debug().Err(err).Str("interface", iface.Name).Msg("Failed to send ICMPv6 echo")
+			continue
+		}
+		swept[iface.Name] = iface
+	}
+	time.Sleep(ndSweepWait)
+
+	// ask every responder for its MAC, neighbor discovery needs hop limit 255
+	mu.Lock()
+	responders := append([]responder{}, order...)
+	mu.Unlock()
+	for _, r := range responders {
+		iface, ok := swept[r.iface]
+		if !ok || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		ns, err := buildNeighborSolicitation(r.ip, iface.HardwareAddr)
+		if err != nil {
+			continue
+		}
+		cm := &ipv6.ControlMessage{IfIndex: iface.Index, HopLimit: 255}
+		if _, err := p.WriteTo(ns, cm, &net.IPAddr{IP: solicitedNode(r.ip), Zone: iface.Name}); err != nil {
+			log.Debug().Err(err).Str("target", r.ip.String()).Msg("Failed to send neighbor solicitation")
+		}
+	}
+	time.Sleep(ndSweepWait)
+	stop()
+
+	neighbors := ARPDiscovery{}
+	for _, r := range order {
+		n := Neighbor{
+			IP:        r.ip.String(),
+			Interface: r.iface,
+			State:     "REACHABLE",
+			Family:    "ipv6",
+		}
+		if mac, ok := macs[r]; ok {
+			n.MAC = normalizeMAC(mac.String())
+		} else if mac, ok := eui64MAC(r.ip); ok {
+			n.MAC = normalizeMAC(mac.String())
+			n.EUI64 = true
+		}
+		log.Debug().
+			Str("IP", n.IP).
+			Str("MAC", n.MAC).
+			Str("interface", n.Interface).
+			Bool("eui64", n.EUI64).
+			Msg("Discovered neighbor via ND sweep")
+		neighbors = append(neighbors, n)
+	}
+	return neighbors, nil
+}
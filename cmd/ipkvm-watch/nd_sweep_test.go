@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestEUI64MAC(t *testing.T) {
+	tests := []struct {
+		ip  string
+		mac string
+		ok  bool
+	}{
+		// the universal/local bit is flipped back: 96 -> 94
+		{"fe80::9683:c4ff:feae:ac2a", "94:83:c4:ae:ac:2a", true},
+		{"fd00::ba27:ebff:fe01:203", "b8:27:eb:01:02:03", true},
+		// a locally administered MAC has the bit clear in the address
+		{"fe80::ff:fe00:1", "02:00:00:00:00:01", true},
+		{"fe80::9683:c4aa:aaae:ac2a", "", false},
+		{"fe80::1", "", false},
+		{"192.168.8.1", "", false},
+		{"::ffff:192.168.8.1", "", false},
+	}
+	for _, tt := range tests {
+		mac, ok := eui64MAC(netip.MustParseAddr(tt.ip))
+		if ok != tt.ok || (ok && mac.String() != tt.mac) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.ip, mac, ok, tt.mac, tt.ok)
+		}
+	}
+}
+
+func TestSolicitedNode(t *testing.T) {
+	for ip, group := range map[string]string{
+		"fe80::9683:c4ff:feae:ac2a": "ff02::1:ffae:ac2a",
+		"2001:db8::1":               "ff02::1:ff00:1",
+	} {
+		if got := solicitedNode(netip.MustParseAddr(ip)); got.String() != group {
+			t.Errorf("%s: got %s, want %s", ip, got, group)
+		}
+	}
+}
+
+func TestBuildNeighborSolicitation(t *testing.T) {
+	target := netip.MustParseAddr("fe80::9683:c4ff:feae:ac2a")
+	mac := net.HardwareAddr{0x02, 0xfc, 0x00, 0x00, 0x00, 0x01}
+	b, err := buildNeighborSolicitation(target, mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// type, code, checksum, reserved, target, source link-layer option
+	if len(b) != 32 {
+		t.Fatalf("got %d bytes, want 32: % x", len(b), b)
+	}
+	if b[0] != byte(ipv6.ICMPTypeNeighborSolicitation) || b[1] != 0 {
+		t.Errorf("got type %d code %d", b[0], b[1])
+	}
+	if !bytes.Equal(b[4:8], []byte{0, 0, 0, 0}) {
+		t.Errorf("got reserved % x", b[4:8])
+	}
+	if got := target.As16(); !bytes.Equal(b[8:24], got[:]) {
+		t.Errorf("got target % x", b[8:24])
+	}
+	if b[24] != ndOptSourceLinkAddr || b[25] != 1 || !bytes.Equal(b[26:32], mac) {
+		t.Errorf("got option % x", b[24:32])
+	}
+}
+
+func TestParseNeighborAdvertisement(t *testing.T) {
+	target := netip.MustParseAddr("fe80::9683:c4ff:feae:ac2a").As16()
+	na := func(options ...byte) []byte {
+		// solicited and override flags
+		body := []byte{0x60, 0, 0, 0}
+		body = append(body, target[:]...)
+		return append(body, options...)
+	}
+	tests := []struct {
+		name string
+		body []byte
+		mac  string
+		ok   bool
+	}{
+		{"target link-layer", na(ndOptTargetLinkAddr, 1, 0x94, 0x83, 0xc4, 0xae, 0xac, 0x2a), "94:83:c4:ae:ac:2a", true},
+		// a nonce option first, the target link-layer option after it
+		{"after another option", na(14, 1, 1, 2, 3, 4, 5, 6, ndOptTargetLinkAddr, 1, 0x94, 0x83, 0xc4, 0xae, 0xac, 0x2a), "94:83:c4:ae:ac:2a", true},
+		{"no option", na(), "", true},
+		{"zero length option", na(ndOptTargetLinkAddr, 0, 0x94, 0x83, 0xc4, 0xae, 0xac, 0x2a), "", true},
+		{"option past the end", na(ndOptTargetLinkAddr, 2, 0x94, 0x83, 0xc4, 0xae, 0xac, 0x2a), "", true},
+		{"short", na()[:19], "", false},
+	}
+	for _, tt := range tests {
+		ip, mac, ok := parseNeighborAdvertisement(tt.body)
+		if ok != tt.ok || mac.String() != tt.mac {
+			t.Errorf("%s: got %s %v, want %s %v", tt.name, mac, ok, tt.mac, tt.ok)
+		}
+		if ok && ip.String() != "fe80::9683:c4ff:feae:ac2a" {
+			t.Errorf("%s: got target %s", tt.name, ip)
+		}
+	}
+}
+
+func TestIsNDEchoReply(t *testing.T) {
+	reply := func(id int) *icmp.Message {
+		b, err := (&icmp.Message{Type: ipv6.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("ipkvm-watch")}}).Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := icmp.ParseMessage(icmpv6Protocol, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	if !isNDEchoReply(reply(ndEchoID)) {
+		t.Error("our echo reply was dropped")
+	}
+	if isNDEchoReply(reply(0x1234)) {
+		t.Error("another tool's echo reply was kept")
+	}
+}
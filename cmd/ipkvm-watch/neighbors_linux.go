@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// procNetArp is a variable so a captured copy can be used instead
+var procNetArp = "/proc/net/arp"
+
+// ATF_* flags from linux/if_arp.h
+const (
+	atfComplete  = 0x02
+	atfPermanent = 0x04
+)
+
+// nudStates are the NUD_* neighbor states from linux/neighbour.h
+var nudStates = []struct {
+	bit  uint16
+	name string
+}{
+	{unix.NUD_INCOMPLETE, "INCOMPLETE"},
+	{unix.NUD_REACHABLE, "REACHABLE"},
+	{unix.NUD_STALE, "STALE"},
+	{unix.NUD_DELAY, "DELAY"},
+	{unix.NUD_PROBE, "PROBE"},
+	{unix.NUD_FAILED, "FAILED"},
+	{unix.NUD_NOARP, "NOARP"},
+	{unix.NUD_PERMANENT, "PERMANENT"},
+}
+
+// parseProcNetArp parses /proc/net/arp
+// IP address       HW type     Flags       HW address            Mask     Device
+// 192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+func parseProcNetArp(input string) ARPDiscovery {
+	neighbors := ARPDiscovery{}
+	for i, line := range strings.Split(input, "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 6 {
+			continue
+		}
+		flags, _ := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		n := Neighbor{
+			IP:        fields[0],
+			Interface: fields[5],
+			Family:    "ipv4",
+		}
+		// the proc file doesn't have the NUD state, only complete or not
+		switch {
+		case flags&atfPermanent != 0:
+			n.State = "PERMANENT"
+		case flags&atfComplete != 0:
+			n.State = "COMPLETE"
+		default:
+			n.State = "INCOMPLETE"
+		}
+		if n.State != "INCOMPLETE" && fields[3] != "00:00:00:00:00:00" {
+			n.MAC = normalizeMAC(fields[3])
+		}
+		neighbors = append(neighbors, n)
+	}
+	return neighbors
+}
+
+// nudStateName turns a NUD_* bitmask into a name, ex: STALE
+func nudStateName(state uint16) string {
+	names := []string{}
+	for _, s := range nudStates {
+		if state&s.bit != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, "|")
+}
+
+// parseNeighMessages reads RTM_NEWNEIGH messages from a netlink dump. Each
+// is a struct ndmsg followed by route attributes (NDA_DST, NDA_LLADDR ...).
+func parseNeighMessages(b []byte, ifName func(int) string) (ARPDiscovery, error) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	neighbors := ARPDiscovery{}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		family := m.Data[0]
+		ifindex := int(int32(binary.NativeEndian.Uint32(m.Data[4:8])))
+		state := binary.NativeEndian.Uint16(m.Data[8:10])
+		n := Neighbor{
+			Interface: ifName(ifindex),
+			State:     nudStateName(state),
+		}
+		switch family {
+		case unix.AF_INET:
+			n.Family = "ipv4"
+		case unix.AF_INET6:
+			n.Family = "ipv6"
+		default:
+			continue
+		}
+		attrs := m.Data[unix.SizeofNdMsg:]
+		for len(attrs) >= unix.SizeofRtAttr {
+			l := int(binary.NativeEndian.Uint16(attrs[0:2]))
+			t := binary.NativeEndian.Uint16(attrs[2:4])
+			if l < unix.SizeofRtAttr || l > len(attrs) {
+				break
+			}
+			value := attrs[unix.SizeofRtAttr:l]
+			switch t {
+			case unix.NDA_DST:
+				n.IP = net.IP(value).String()
+			case unix.NDA_LLADDR:
+				if len(value) == 6 {
+					n.MAC = normalizeMAC(net.HardwareAddr(value).String())
+				}
+			}
+			// attributes are padded to 4 bytes
+			l = (l + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+			if l > len(attrs) {
+				break
+			}
+			attrs = attrs[l:]
+		}
+		if n.IP == "" {
+			continue
+		}
+		neighbors = append(neighbors, n)
+	}
+	return neighbors, nil
+}
+
+// netlinkNeighbors dumps the kernel neighbor table for a family
+func netlinkNeighbors(family int) (ARPDiscovery, error) {
+	b, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, family)
+	if err != nil {
+		return nil, fmt.Errorf("neighbor dump failed: %w", err)
+	}
+	return parseNeighMessages(b, func(index int) string {
+		iface, err := net.InterfaceByIndex(index)
+		if err != nil {
+			return strconv.Itoa(index)
+		}
+		return iface.Name
+	})
+}
+
+// linuxNeighbors reads IPv4 neighbors from /proc/net/arp and the IPv6 (and
+// the IPv4 NUD states) over rtnetlink, without needing net-tools
+func linuxNeighbors() (ARPDiscovery, error) {
+	neighbors := ARPDiscovery{}
+	b, err := os.ReadFile(procNetArp)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read the ARP table")
+	} else {
+		neighbors = parseProcNetArp(string(b))
+	}
+	dump, nlErr := netlinkNeighbors(unix.AF_UNSPEC)
+	if nlErr != nil {
+		log.Error().Err(nlErr).Msg("Failed to read the neighbor table over rtnetlink")
+		if err != nil {
+			return nil, fmt.Errorf("no neighbor table available: %w", err)
+		}
+	}
+	for _, n := range dump {
+		i := slices.IndexFunc(neighbors, func(p Neighbor) bool {
+			return p.IP == n.IP && p.Interface == n.Interface
+		})
+		if i >= 0 {
+			neighbors[i].State = n.State
+			continue
+		}
+		neighbors = append(neighbors, n)
+	}
+	result := ARPDiscovery{}
+	for _, n := range neighbors {
+		// the kernel keeps NOARP entries for multicast groups, they aren't hosts
+		ip := net.ParseIP(n.IP)
+		if slices.Contains(IP_EXCLUSION, n.IP) || ip == nil || ip.IsMulticast() || ip.IsUnspecified() {
+			continue
+		}
+		log.Debug().
+			Str("IP", n.IP).
+			Str("MAC", n.MAC).
+			Str("interface", n.Interface).
+			Str("state", n.State).
+			Msg("Discovered neighbor")
+		result = append(result, n)
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestParseProcNetArp(t *testing.T) {
+	got := neighborStrings(parseProcNetArp(readFixture(t, "testdata/neighbors/proc_net_arp")))
+	want := []string{
+		"ipv4 192.168.77.13  v0 INCOMPLETE",
+		"ipv4 192.168.77.11 b8:27:eb:5c:10:8e v0 COMPLETE",
+		"ipv4 192.168.77.14  v0 INCOMPLETE",
+		"ipv4 192.168.77.10 94:83:c4:ae:ac:2a v0 PERMANENT",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// rtm_newneigh is an RTM_GETNEIGH dump (little endian) of a netns with
+// static, stale, reachable, incomplete and failed neighbors on v0 (index 3)
+func TestParseNeighMessages(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the dump was captured on a little endian machine")
+	}
+	b := []byte(readFixture(t, "testdata/neighbors/rtm_newneigh"))
+	neighbors, err := parseNeighMessages(b, func(index int) string {
+		return map[int]string{1: "lo", 2: "v1", 3: "v0"}[index]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := neighborStrings(neighbors)
+	want := []string{
+		"ipv4 192.168.77.13  v0 FAILED",
+		"ipv4 192.168.77.11 b8:27:eb:5c:10:8e v0 STALE",
+		"ipv4 192.168.77.14  v0 INCOMPLETE",
+		"ipv4 192.168.77.12  v0 NONE",
+		"ipv4 192.168.77.10 94:83:c4:ae:ac:2a v0 PERMANENT",
+		"ipv6 fd77::30  v0 INCOMPLETE",
+		"ipv6 fd77::20 b8:27:eb:5c:10:8e v0 REACHABLE",
+	}
+	for _, w := range want {
+		if !slices.Contains(got, w) {
+			t.Errorf("missing %q in %q", w, got)
+		}
+	}
+	// the multicast groups and lo's 0.0.0.0 are there too, linuxNeighbors drops them
+	if len(got) != 16 {
+		t.Errorf("got %d neighbors, want 16", len(got))
+	}
+}
+
+func TestNUDStateName(t *testing.T) {
+	if got := nudStateName(0x04 | 0x08); got != "STALE|DELAY" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// linuxNeighbors reads kernel tables which are linux only, other OSes use `arp -a`
+func linuxNeighbors() (ARPDiscovery, error) {
+	return nil, fmt.Errorf("native neighbor table is not supported on %s", runtime.GOOS)
+}
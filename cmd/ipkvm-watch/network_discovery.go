@@ -3,36 +3,96 @@ package main
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
 	"os/exec"
 	"runtime"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/pion/mdns/v2"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
 )
 
 // Discovery has a few phases
 // 1. ARP to gather IPs on the same broadcast domain
 // 2. mDNS lookups for indicator domains
 
-type ARPDiscovery struct {
-	IPs  []string
-	MACs []string
+// Neighbor is one entry of the ARP (IPv4) or NDP (IPv6) neighbor table
+type Neighbor struct {
+	IP        string
+	MAC       string
+	Interface string
+	State     string // ex: REACHABLE, STALE, INCOMPLETE, FAILED
+	Family    string // ipv4 or ipv6
+	EUI64     bool   // MAC derived from the IPv6 interface id, not seen on the wire
+	MACClass  string // globally-unique, locally-administered or randomized
+	// Organization the MAC's block is registered to in the IEEE registry
+	Organization string
+	Hostnames    []string // from reverse DNS and DHCP leases
 }
+
+// ARPDiscovery is the host's neighbor table
+type ARPDiscovery []Neighbor
+
+// ARPResult is a MAC that matched a vendor's prefix, with every address
+// and adapter it was seen on
 type ARPResult struct {
-	MAC    string
-	Vendor string
+	MAC          string
+	Vendor       string
+	IPs          []string
+	Interfaces   []string
+	Prefix       string
+	RuleType     string // ex: oui, oui-28, oui-36, mask, bits
+	MACClass     string
+	Organization string
+	Confidence   string
+	Reference    string
+	EUI64        bool
 }
+
+// MDNSResult is an indicator domain that resolved, with every address it
+// resolved to
 type MDNSResult struct {
 	Domain string
 	Vendor string
 	IPv4s  []net.IP
 	IPv6s  []*net.IPAddr
+	Source string // address the answer came from, empty when the tool doesn't say
+	TTL    uint32 // lowest TTL of the answers, 0 when the tool doesn't say
+	Method string // native, subprocess, passive, dnssd, http, rdns or dhcp
+	// Interface and Family (ipv4 or ipv6) the answer came in on
+	Interface string
+	Family    string
+	Pattern   string // the indicator that matched, a name, glob or re:<expr>
+}
+
+// addAddr adds an A or AAAA answer once
+func (m *MDNSResult) addAddr(addr netip.Addr) {
+	if addr.Is4() || addr.Is4In6() {
+		ip := net.IP(addr.Unmap().AsSlice())
+		if !slices.ContainsFunc(m.IPv4s, ip.Equal) {
+			m.IPv4s = append(m.IPv4s, ip)
+		}
+		return
+	}
+	ip := &net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+	if !slices.ContainsFunc(m.IPv6s, func(a *net.IPAddr) bool { return a.String() == ip.String() }) {
+		m.IPv6s = append(m.IPv6s, ip)
+	}
+}
+
+// addTTL keeps the lowest TTL seen
+func (m *MDNSResult) addTTL(ttl uint32) {
+	if m.TTL == 0 || ttl < m.TTL {
+		m.TTL = ttl
+	}
+}
+
+// found reports whether the domain resolved to anything
+func (m *MDNSResult) found() bool {
+	return len(m.IPv4s) > 0 || len(m.IPv6s) > 0
 }
 
 var IP_EXCLUSION = []string{
@@ -41,67 +101,166 @@ var IP_EXCLUSION = []string{
 	"169.254.169.254",
 }
 
-func parseArpWindows(arpInput string) (ARPDiscovery, error) {
+// IPs returns the addresses of the neighbors that answered, these are the
+// ones the http checks are run against. IPv6 link-local addresses get the
+// interface as their zone, ex: fe80::1%eth0
+func (a ARPDiscovery) IPs() []string {
 	ips := []string{}
-	macs := []string{}
+	for _, n := range a {
+		if n.State == "INCOMPLETE" || n.State == "FAILED" || (n.MAC == "" && n.State == "") {
+			continue
+		}
+		ip := n.IP
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() && addr.IsLinkLocalUnicast() && addr.Zone() == "" && n.Interface != "" {
+			ip = addr.WithZone(n.Interface).String()
+		}
+		if !slices.Contains(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// annotate labels every neighbor's MAC and looks up who it's registered to
+func (a ARPDiscovery) annotate(oui OUIRegistry) {
+	for i := range a {
+		a[i].MACClass = classifyMAC(a[i].MAC)
+		a[i].Organization = oui.Lookup(a[i].MAC)
+	}
+}
+
+// addHostnames gives every neighbor the names found for its address
+func (a ARPDiscovery) addHostnames(names []observedName) {
+	for i := range a {
+		a[i].Hostnames = []string{}
+		addr, err := netip.ParseAddr(a[i].IP)
+		if err != nil {
+			continue
+		}
+		for _, n := range names {
+			if slices.ContainsFunc(n.Addrs, func(b netip.Addr) bool { return b.WithZone("") == addr.WithZone("") }) && !slices.Contains(a[i].Hostnames, n.Name) {
+				a[i].Hostnames = append(a[i].Hostnames, n.Name)
+			}
+		}
+	}
+}
+
+// hostnamesByIP maps the addresses to the first name found for them, the
+// keys are written like IPs() writes them
+func hostnamesByIP(names []observedName) map[string]string {
+	hostnames := map[string]string{}
+	for _, n := range names {
+		for _, addr := range n.Addrs {
+			if _, ok := hostnames[addr.String()]; !ok {
+				hostnames[addr.String()] = n.Name
+			}
+		}
+	}
+	return hostnames
+}
+
+// normalizeMAC writes a MAC as lowercase, colon separated and zero padded
+// ex: E6-C0-0B-4B-0D-26 and e6:c0:b:4b:d:26 -> e6:c0:0b:4b:0d:26
+func normalizeMAC(mac string) string {
+	mac = strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+	parts := strings.Split(mac, ":")
+	if len(parts) != 6 {
+		return mac
+	}
+	for i, part := range parts {
+		if len(part) == 1 {
+			parts[i] = "0" + part
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+func parseArpWindows(arpInput string) (ARPDiscovery, error) {
+	neighbors := ARPDiscovery{}
+	iface := ""
 	// sample input
 	// Interface: 172.26.176.1 --- 0x48
 	//   Internet Address      Physical Address      Type
 	//   172.26.182.63         00-15-5d-e8-bf-8b     dynamic
 	//   172.26.191.255        ff-ff-ff-ff-ff-ff     static
+	//   172.26.182.70         00-00-00-00-00-00     invalid
 	for _, line := range strings.Split(arpInput, "\n") {
-		// skip lines without double spaces, those are interface defs
-		// and headers (Internet Address....)
-		if strings.HasPrefix(line, "Interface ") || strings.HasPrefix(line, "  Internet ") {
+		line = strings.TrimRight(line, "\r")
+		// interface defs name the adapter by its address, skip the headers
+		// (Internet Address....)
+		if strings.HasPrefix(line, "Interface: ") {
+			iface = strings.Fields(line)[1]
+			continue
+		}
+		if strings.HasPrefix(line, "  Internet ") {
 			continue
 		}
-		//
+		// "No ARP Entries Found." is the only line when the table is empty
 		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			ip := fields[0]
-			mac := fields[1]
-			// windows returns MACs with dashes and indicators are written with colons
-			// so we're going to replace - with : on the macs
-			mac = strings.Replace(mac, "-", ":", -1)
-			ips = append(ips, ip)
-			macs = append(macs, mac)
+		if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+			n := Neighbor{
+				IP:        fields[0],
+				Interface: iface,
+				Family:    "ipv4",
+			}
+			if len(fields) >= 3 {
+				n.State = strings.ToUpper(fields[2])
+			}
+			// windows returns MACs with dashes and indicators are written with colons,
+			// unresolved (invalid) entries have an all zero MAC
+			if mac := normalizeMAC(fields[1]); mac != "00:00:00:00:00:00" {
+				n.MAC = mac
+			}
+			neighbors = append(neighbors, n)
 		}
 	}
-	return ARPDiscovery{
-			IPs:  ips,
-			MACs: macs,
-		},
-		nil
+	return neighbors, nil
 }
+
 func parseArpNixMac(arpInput string) (ARPDiscovery, error) {
-	ips := []string{}
-	macs := []string{}
+	neighbors := ARPDiscovery{}
 	for _, line := range strings.Split(arpInput, "\n") {
 		// parse line to get IP address
 		// sample line is "? (192.168.68.56) at e6:c0:b:4b:d:26 on en0 ifscope "
+		// linux net-tools is "? (192.168.1.1) at aa:bb:cc:dd:ee:ff [ether] on eth0"
+		// and unanswered entries are "(incomplete)" or "<incomplete>"
 		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			// get the IP
-			ip := strings.Trim(parts[1], "()")
-			if slices.Contains(IP_EXCLUSION, ip) {
-				continue
+		if len(parts) < 4 || parts[2] != "at" {
+			continue
+		}
+		// get the IP
+		ip := strings.Trim(parts[1], "()")
+		if slices.Contains(IP_EXCLUSION, ip) {
+			continue
+		}
+		n := Neighbor{IP: ip, Family: "ipv4"}
+		if i := slices.Index(parts, "on"); i >= 0 && i+1 < len(parts) {
+			n.Interface = parts[i+1]
+		}
+		if strings.Contains(parts[3], "incomplete") {
+			n.State = "INCOMPLETE"
+		} else {
+			n.MAC = normalizeMAC(parts[3])
+			if slices.Contains(parts, "PERM") || slices.Contains(parts, "permanent") {
+				n.State = "PERMANENT"
 			}
-			log.Debug().Str("IP", ip).Msg("Discovered IP via ARP")
-			ips = append(ips, ip)
-			// get the MAC
-			mac := parts[3]
-			log.Debug().Str("MAC", mac).Msg("Discovered MAC via ARP")
-			macs = append(macs, mac)
 		}
+		log.Debug().Str("IP", n.IP).Str("MAC", n.MAC).Str("interface", n.Interface).Msg("Discovered neighbor via ARP")
+		neighbors = append(neighbors, n)
 	}
-	return ARPDiscovery{
-			IPs:  ips,
-			MACs: macs,
-		},
-		nil
+	return neighbors, nil
 }
 
 func arpDiscovery() (ARPDiscovery, error) {
+	// linux reads the kernel tables, net-tools (arp) is missing on most
+	// current distros
+	if runtime.GOOS == "linux" {
+		neighbors, err := linuxNeighbors()
+		if err == nil {
+			return neighbors, nil
+		}
+		log.Warn().Err(err).Msg("Native neighbor discovery failed, trying arp -a")
+	}
 	// run arp -a and parse output
 	cmd := exec.Command("arp", "-a")
 	stdout, err := cmd.Output()
@@ -120,34 +279,117 @@ func arpDiscovery() (ARPDiscovery, error) {
 	}
 }
 
-func checkARPMacs(mac_indicators map[string]MACPrefixGroup, discovered_macs []string) []ARPResult {
+// checkARPMacs matches the MAC prefixes against the neighbor table. A MAC
+// seen on several adapters or with several addresses is reported once with
+// all of them, and once per vendor using that vendor's most confident
+// matching prefix.
+func checkARPMacs(mac_indicators map[string]MACPrefixGroup, neighbors ARPDiscovery) []ARPResult {
 	matched_vendors := []ARPResult{}
-	for vendor, prefix_group := range mac_indicators {
-		for _, prefix_entry := range prefix_group.Prefixes {
-			prefix := strings.ToLower(prefix_entry.Prefix)
-			for _, discovered_mac := range discovered_macs {
-				discovered_mac = strings.ToLower(discovered_mac)
-				if strings.HasPrefix(discovered_mac, prefix) {
-					a := ARPResult{
-						MAC:    discovered_mac,
-						Vendor: vendor,
-					}
-					log.Info().Str("MAC", discovered_mac).Str("vendor", vendor).Msg("Matched MAC prefix")
-					matched_vendors = append(matched_vendors, a)
+	macs := []string{}
+	byMAC := map[string]*ARPResult{}
+	for _, n := range neighbors {
+		if n.MAC == "" {
+			continue
+		}
+		mac := strings.ToLower(n.MAC)
+		a, ok := byMAC[mac]
+		if !ok {
+			a = &ARPResult{MAC: mac, IPs: []string{}, Interfaces: []string{}, MACClass: classifyMAC(mac), Organization: n.Organization}
+			byMAC[mac] = a
+			macs = append(macs, mac)
+		}
+		if !slices.Contains(a.IPs, n.IP) {
+			a.IPs = append(a.IPs, n.IP)
+		}
+		if n.Interface != "" && !slices.Contains(a.Interfaces, n.Interface) {
+			a.Interfaces = append(a.Interfaces, n.Interface)
+		}
+		if n.EUI64 {
+			a.EUI64 = true
+		}
+	}
+
+	// parse the prefixes once, bad ones are skipped
+	vendors := slices.Sorted(maps.Keys(mac_indicators))
+	prefixes := map[string][]*macPrefix{}
+	for _, vendor := range vendors {
+		for _, prefix_entry := range mac_indicators[vendor].Prefixes {
+			var p *macPrefix
+			if prefix_entry.Prefix != "" {
+				parsed, err := parseMACPrefix(prefix_entry.Prefix)
+				if err != nil {
+					log.Warn().Err(err).Str("vendor", vendor).Msg("Skipping MAC indicator")
+					prefixes[vendor] = append(prefixes[vendor], nil)
+					continue
+				}
+				p = &parsed
+			} else if prefix_entry.LocallyAdministered == nil && prefix_entry.Multicast == nil && prefix_entry.Class == "" && prefix_entry.Organization == "" {
+				log.Warn().Str("vendor", vendor).Msg("Skipping MAC indicator without a prefix, bit, class or organization rule")
+				prefixes[vendor] = append(prefixes[vendor], nil)
+				continue
+			}
+			prefixes[vendor] = append(prefixes[vendor], p)
+		}
+	}
+
+	for _, mac := range macs {
+		for _, vendor := range vendors {
+			var best *MACPrefixEntry
+			ruleType := ""
+			for i, prefix_entry := range mac_indicators[vendor].Prefixes {
+				p := prefixes[vendor][i]
+				if p == nil && prefix_entry.Prefix != "" {
+					continue
+				}
+				kind, ok := matchMACEntry(prefix_entry, p, mac, byMAC[mac].Organization)
+				if !ok {
+					continue
+				}
+				if best == nil || confidenceRank(prefix_entry.Confidence) > confidenceRank(best.Confidence) {
+					best = &mac_indicators[vendor].Prefixes[i]
+					ruleType = kind
 				}
 			}
+			if best == nil {
+				continue
+			}
+			a := *byMAC[mac]
+			a.Vendor = vendor
+			a.Prefix = best.Prefix
+			a.RuleType = ruleType
+			a.Confidence = best.Confidence
+			if a.Confidence == "" {
+				a.Confidence = "low"
+			}
+			a.Reference = best.Reference
+			log.Info().
+				Str("MAC", mac).
+				Str("vendor", vendor).
+				Str("prefix", a.Prefix).
+				Str("rule", a.RuleType).
+				Str("organization", a.Organization).
+				Strs("ips", a.IPs).
+				Strs("interfaces", a.Interfaces).
+				Msg("Matched MAC prefix")
+			matched_vendors = append(matched_vendors, a)
 		}
 	}
 	return matched_vendors
 }
 
+// mDNSDiscoverySubp resolves the indicator domains with the OS tools
+// instead of opening the mDNS port ourselves
 func mDNSDiscoverySubp(mdns_indicators map[string][]string) ([]MDNSResult, error) {
 	found_domains := []MDNSResult{}
 
 	for vendor, domains := range mdns_indicators {
 		for _, domain := range domains {
+			if isNamePattern(domain) {
+				continue
+			}
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
+			var mdns_result MDNSResult
 			if runtime.GOOS == "darwin" {
 				cmd := exec.CommandContext(ctx, "dscacheutil", "-q", "host", "-a", "name", domain)
 				stdout, err := cmd.Output()
@@ -155,99 +397,33 @@ func mDNSDiscoverySubp(mdns_indicators map[string][]string) ([]MDNSResult, error
 					log.Err(err).Str("domain", domain).Msg("Failed to run dscacheutil command")
 					continue
 				}
-				if string(stdout) != "" {
-					log.Debug().Str("domain", domain).Msg("Discovered domain via mDNS")
-					mdns_result := MDNSResult{
-						Domain: domain,
-						Vendor: vendor,
-						IPv4s:  []net.IP{},
-						IPv6s:  []*net.IPAddr{},
-					}
-					found_domains = append(found_domains, mdns_result)
-				}
+				mdns_result = parseDscacheutil(string(stdout), domain)
 			} else if runtime.GOOS == "linux" {
-				cmd := exec.CommandContext(ctx, "dig", domain, "-p", "5353", "@224.0.0.251")
+				// dig only asks for one record type per name, so ask twice
+				cmd := exec.CommandContext(ctx, "dig", "-p", "5353", "@224.0.0.251", domain, "A", domain, "AAAA")
 				stdout, err := cmd.Output()
 				if err != nil {
-					log.Err(err).Str("domain", domain).Msg("Failed to run dscacheutil command")
+					log.Err(err).Str("domain", domain).Msg("Failed to run dig command")
 					continue
 				}
-				if strings.Contains(string(stdout), "ANSWER SECTION") {
-					log.Debug().Str("domain", domain).Msg("Discovered domain via mDNS")
-					mdns_result := MDNSResult{
-						Domain: domain,
-						Vendor: vendor,
-						IPv4s:  []net.IP{},
-						IPv6s:  []*net.IPAddr{},
-					}
-					found_domains = append(found_domains, mdns_result)
-				}
+				mdns_result = parseDig(string(stdout), domain)
 			} else if runtime.GOOS == "windows" {
 				// powershell.exe /c Resolve-DnsName glkvm.local
 				cmd := exec.CommandContext(ctx, "powershell.exe", "/c", "Resolve-DnsName", domain)
 				stdout, err := cmd.Output()
 				if err != nil {
-					log.Err(err).Str("domain", domain).Msg("Failed to run dscacheutil command")
+					log.Err(err).Str("domain", domain).Msg("Failed to run Resolve-DnsName command")
 					continue
 				}
-				if strings.Contains(string(stdout), "IPAddress") {
-					log.Debug().Str("domain", domain).Msg("Discovered domain via mDNS")
-					mdns_result := MDNSResult{
-						Domain: domain,
-						Vendor: vendor,
-						IPv4s:  []net.IP{},
-						IPv6s:  []*net.IPAddr{},
-					}
-					found_domains = append(found_domains, mdns_result)
-				}
+				mdns_result = parseResolveDnsName(string(stdout), domain)
 			}
-
-		}
-	}
-	return found_domains, nil
-}
-
-func resolveMDNSNames(mdns_indicators map[string][]string) ([]MDNSResult, error) {
-	found_domains := []MDNSResult{}
-	// build the things we need for mdns resolution
-	// ipv4
-	addr4, err := net.ResolveUDPAddr("udp4", mdns.DefaultAddressIPv4)
-	if err != nil {
-		return found_domains, err
-	}
-	l4, err := net.ListenUDP("udp4", addr4)
-	if err != nil {
-		return found_domains, err
-	}
-	packetConnV4 := ipv4.NewPacketConn(l4)
-	// make a nil ipv6 packet conn
-	var packetConnV6 *ipv6.PacketConn
-	// create the mdns server
-	server, err := mdns.Server(packetConnV4, packetConnV6, &mdns.Config{})
-	if err != nil {
-		return found_domains, err
-	}
-	//now iterate over the domains
-	for vendor, domains := range mdns_indicators {
-		for _, domain := range domains {
-			mdns_result := MDNSResult{
-				Domain: domain,
-				Vendor: vendor,
-				IPv4s:  []net.IP{},
-				IPv6s:  []*net.IPAddr{},
-			}
-
-			// perform query
-			// create a context with a 3 second timeout
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-			defer cancel()
-			answer, src, err := server.QueryAddr(ctx, domain)
-			if err != nil {
-				log.Debug().Err(err).Str("hostname", domain).Msg("mDNS query failed")
+			if !mdns_result.found() {
 				continue
 			}
-			log.Info().Str("answer", answer.GoString()).Str("src", src.String()).Msg("mDNS query result")
-
+			mdns_result.Vendor = vendor
+			mdns_result.Method = "subprocess"
+			mdns_result.Pattern = domain
+			log.Debug().Str("domain", domain).Str("source", mdns_result.Source).Msg("Discovered domain via mDNS")
 			found_domains = append(found_domains, mdns_result)
 		}
 	}
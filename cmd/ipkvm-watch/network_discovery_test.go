@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func neighborStrings(neighbors ARPDiscovery) []string {
+	s := []string{}
+	for _, n := range neighbors {
+		s = append(s, fmt.Sprintf("%s %s %s %s %s", n.Family, n.IP, n.MAC, n.Interface, n.State))
+	}
+	return s
+}
+
+func TestParseArpWindows(t *testing.T) {
+	neighbors, err := parseArpWindows(readFixture(t, "testdata/neighbors/arp_windows"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := neighborStrings(neighbors)
+	want := []string{
+		"ipv4 192.168.8.1 94:83:c4:ae:ac:2a 192.168.8.100 DYNAMIC",
+		"ipv4 192.168.8.23  192.168.8.100 INVALID",
+		"ipv4 192.168.8.31 b8:27:eb:5c:10:8e 192.168.8.100 DYNAMIC",
+		"ipv4 192.168.8.255 ff:ff:ff:ff:ff:ff 192.168.8.100 STATIC",
+		"ipv4 224.0.0.22 01:00:5e:00:00:16 192.168.8.100 STATIC",
+		"ipv4 224.0.0.251 01:00:5e:00:00:fb 192.168.8.100 STATIC",
+		"ipv4 172.26.182.63 00:15:5d:e8:bf:8b 172.26.176.1 DYNAMIC",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseArpNixMac(t *testing.T) {
+	neighbors, err := parseArpNixMac(readFixture(t, "testdata/neighbors/arp_macos") +
+		"? (192.168.1.1) at aa:bb:cc:dd:ee:ff [ether] PERM on eth0\n" +
+		"? (192.168.1.7) at <incomplete> on eth0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := neighborStrings(neighbors)
+	want := []string{
+		"ipv4 192.168.8.1 94:83:c4:ae:ac:2a en0 ",
+		"ipv4 192.168.8.23  en0 INCOMPLETE",
+		"ipv4 192.168.8.31 b8:27:eb:5c:10:8e en0 PERMANENT",
+		"ipv4 192.168.8.44 3c:22:fb:01:aa:7e en0 ",
+		"ipv4 192.168.8.255 ff:ff:ff:ff:ff:ff en0 ",
+		"ipv4 192.168.1.1 aa:bb:cc:dd:ee:ff eth0 PERMANENT",
+		"ipv4 192.168.1.7  eth0 INCOMPLETE",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"slices"
 	"strings"
 	"sync"
@@ -28,11 +31,17 @@ type HTTPFinding struct {
 	Confidence string
 	Type       string
 	Value      string
-	Hostname   string
+	Hostname   string // the name found for an IP target, or the target
+	IP         string // empty when the target is a name
 }
 
-func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HTTPFinding {
+// httpQueries checks the certificate, title and favicon of every target.
+// Findings on an IP target are reported under the name hostnames has for
+// it. It also returns the host names the targets gave away, the names in
+// their certificates and the hosts they redirect to.
+func httpQueries(ips []string, domainNames []string, indicators HTTPConfig, hostnames map[string]string) ([]HTTPFinding, []observedName) {
 	httpFindings := []HTTPFinding{}
+	names := []observedName{}
 	// remove duplicates from ips
 	slices.Sort(ips)
 	ips = slices.Compact(ips)
@@ -54,12 +63,20 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
+			hostname, ip := target, ""
+			if _, err := netip.ParseAddr(target); err == nil {
+				ip = target
+				if name, ok := hostnames[target]; ok {
+					hostname = name
+				}
+			}
+
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
 			d := tls.Dialer{
 				Config: conf,
 			}
-			conn, err := d.DialContext(ctx, "tcp", fmt.Sprintf("%s:443", target))
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(target, "443"))
 			if err != nil {
 				log.Error().Err(err).Str("target", target).Msg("Error in Dial for SSL check")
 				return
@@ -68,6 +85,15 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 			certs := tlsConn.ConnectionState().PeerCertificates
 			conn.Close()
 
+			if len(certs) > 0 {
+				leaf := certs[0]
+				mu.Lock()
+				for _, name := range append([]string{leaf.Subject.CommonName}, leaf.DNSNames...) {
+					names = appendHTTPName(names, name, target)
+				}
+				mu.Unlock()
+			}
+
 			for _, cert := range certs {
 				// check org and org unit against indicators
 				for vendor, indicator_org := range indicators.SSL {
@@ -78,7 +104,8 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Confidence: "high",
 								Type:       "SSL",
 								Value:      certorg,
-								Hostname:   target,
+								Hostname:   hostname,
+								IP:         ip,
 							}
 							mu.Lock()
 							httpFindings = append(httpFindings, f)
@@ -89,14 +116,20 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Str("type", f.Type).
 								Str("value", f.Value).
 								Str("hostname", f.Hostname).
+								Str("ip", f.IP).
 								Msg("SSL certificate match found")
 						}
 					}
 				}
 			}
 
-			url := fmt.Sprintf("https://%s", target)
-			title, err := getPageElement(url, Title)
+			url := fmt.Sprintf("https://%s", urlHost(target))
+			title, redirects, err := getPageElement(url, Title)
+			mu.Lock()
+			for _, host := range redirects {
+				names = appendHTTPName(names, host, target)
+			}
+			mu.Unlock()
 			if err != nil {
 				log.Error().Err(err).Str("url", url).Msg("Error fetching title")
 			} else {
@@ -109,7 +142,8 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Confidence: "medium",
 								Type:       "Title",
 								Value:      title,
-								Hostname:   target,
+								Hostname:   hostname,
+								IP:         ip,
 							}
 							mu.Lock()
 							httpFindings = append(httpFindings, f)
@@ -120,6 +154,7 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Str("type", f.Type).
 								Str("value", f.Value).
 								Str("hostname", f.Hostname).
+								Str("ip", f.IP).
 								Msg("Page title match found")
 						}
 					}
@@ -138,7 +173,8 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Confidence: "high",
 								Type:       "Favicon",
 								Value:      favicon_hash,
-								Hostname:   target,
+								Hostname:   hostname,
+								IP:         ip,
 							}
 							mu.Lock()
 							httpFindings = append(httpFindings, f)
@@ -149,6 +185,7 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 								Str("type", f.Type).
 								Str("value", f.Value).
 								Str("hostname", f.Hostname).
+								Str("ip", f.IP).
 								Msg("Page title match found")
 						}
 					}
@@ -157,12 +194,39 @@ func httpQueries(ips []string, domainNames []string, indicators HTTPConfig) []HT
 		}(target)
 	}
 	wg.Wait()
-	return httpFindings
+	return httpFindings, names
+}
+
+// appendHTTPName adds a host name a target gave away, addresses and
+// wildcards aren't names
+func appendHTTPName(names []observedName, name string, target string) []observedName {
+	name = canonicalName(name)
+	if name == "" || strings.ContainsAny(name, " *") || name == canonicalName(target) {
+		return names
+	}
+	if _, err := netip.ParseAddr(name); err == nil {
+		return names
+	}
+	n := observedName{Name: name, Method: "http", Source: target}
+	if addr, err := netip.ParseAddr(target); err == nil {
+		n.Addrs = []netip.Addr{addr}
+	}
+	return append(names, n)
+}
+
+// urlHost brackets IPv6 addresses for use in a url, the zone's % has to
+// be escaped, ex: fe80::1%eth0 -> [fe80::1%25eth0]
+func urlHost(target string) string {
+	addr, err := netip.ParseAddr(target)
+	if err != nil || !addr.Is6() {
+		return target
+	}
+	return "[" + strings.Replace(addr.String(), "%", "%25", 1) + "]"
 }
 
 func getFaviconHash(url string) (string, error) {
 	// try to parse the favicon localtion from the page
-	favicon_loc, err := getPageElement(url, Favicon)
+	favicon_loc, _, err := getPageElement(url, Favicon)
 	if err != nil {
 		log.Info().Err(err).Msg("Error getting favicon location. Trying /favicon.ico")
 		favicon_loc = "/favicon.ico"
@@ -212,29 +276,42 @@ func getFaviconHash(url string) (string, error) {
 	return md5HashString, nil
 }
 
-func getPageElement(url string, searchElement PageSearchElement) (string, error) {
+// getPageElement returns the title or favicon location of a page and the
+// hosts it redirected through on the way there
+func getPageElement(url string, searchElement PageSearchElement) (string, []string, error) {
 	// 1. Fetch the webpage
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()      // Clone default transport to keep other settings
 	customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // Ignore SSL verification
 
 	// Create an HTTP client with the custom transport
-	client := &http.Client{Transport: customTransport}
+	redirects := []string{}
+	client := &http.Client{
+		Transport: customTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			redirects = append(redirects, req.URL.Hostname())
+			// same limit as the default policy
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
 	resp, err := client.Get(url)
 	if err != nil {
-		return "", fmt.Errorf("error fetching URL: %w", err)
+		return "", redirects, fmt.Errorf("error fetching URL: %w", err)
 	}
 	// Ensure the response body is closed after the function exits
 	defer resp.Body.Close()
 
 	// Check for a successful HTTP status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+		return "", redirects, fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
 	}
 
 	// 2. Parse the HTML body
 	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("error parsing HTML: %w", err)
+		return "", redirects, fmt.Errorf("error parsing HTML: %w", err)
 	}
 
 	// 3. Find and extract the title
@@ -279,8 +356,8 @@ func getPageElement(url string, searchElement PageSearchElement) (string, error)
 	f(doc)
 
 	if return_element == "" {
-		return "", fmt.Errorf("title tag not found or is empty")
+		return "", redirects, fmt.Errorf("title tag not found or is empty")
 	}
 
-	return return_element, nil
+	return return_element, redirects, nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ouiSeed is a hand-picked slice of the IEEE MA-L registry in the CSV
+// format the IEEE publishes, only the Raspberry Pi and GL Technologies
+// blocks. `go generate` replaces it with every MA-L, MA-M and MA-S
+// assignment of the KVM vendors and the indicators.yaml prefixes, the full
+// files can be passed with -oui.
+//
+//go:generate go run oui_generate.go
+//go:embed oui_seed.csv
+var ouiSeed string
+
+// OUIRegistry maps IEEE assignments (6, 7 or 9 hex digits for MA-L, MA-M
+// and MA-S) to the organization they're registered to
+type OUIRegistry map[string]string
+
+// loadOUIRegistry reads the embedded seed and then every CSV file given,
+// later files override earlier assignments. Files that fail to parse are
+// logged and skipped.
+func loadOUIRegistry(paths []string) OUIRegistry {
+	r := OUIRegistry{}
+	if err := r.read(strings.NewReader(ouiSeed)); err != nil {
+		log.Error().Err(err).Msg("Failed to read the embedded OUI registry")
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to open OUI registry")
+			continue
+		}
+		err = r.read(f)
+		f.Close()
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to read OUI registry")
+			continue
+		}
+	}
+	log.Debug().Int("assignments", len(r)).Msg("Loaded OUI registry")
+	return r
+}
+
+// read adds the rows of an IEEE registry CSV, the columns are found by
+// their header names: Registry,Assignment,Organization Name,...
+func (r OUIRegistry) read(in io.Reader) error {
+	c := csv.NewReader(in)
+	c.FieldsPerRecord = -1
+	header, err := c.Read()
+	if err != nil {
+		return err
+	}
+	assignment, organization := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")) {
+		case "Assignment":
+			assignment = i
+		case "Organization Name":
+			organization = i
+		}
+	}
+	if assignment < 0 || organization < 0 {
+		return fmt.Errorf("missing Assignment or Organization Name column")
+	}
+	for {
+		row, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) <= assignment || len(row) <= organization {
+			continue
+		}
+		a := strings.ToUpper(strings.TrimSpace(row[assignment]))
+		if len(a) != 6 && len(a) != 7 && len(a) != 9 {
+			continue
+		}
+		r[a] = strings.TrimSpace(row[organization])
+	}
+}
+
+// Lookup returns the organization of the longest assignment covering the
+// MAC, MA-S before MA-M before MA-L since the small blocks are carved out
+// of large ones (ex: 70:B3:D5 is the IEEE registration authority itself)
+func (r OUIRegistry) Lookup(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+	digits := strings.ToUpper(fmt.Sprintf("%x", []byte(hw)))
+	for _, n := range []int{9, 7, 6} {
+		if org, ok := r[digits[:n]]; ok {
+			return org
+		}
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+//go:build ignore
+
+// oui_generate.go replaces oui_seed.csv with an extract of the IEEE MA-L,
+// MA-M and MA-S registries. It keeps every assignment of the organizations
+// below and every assignment covering a prefix from indicators.yaml. The
+// committed oui_seed.csv predates it, run it to pick up the rest.
+//
+//	go generate ./cmd/ipkvm-watch
+//	go run oui_generate.go -src <dir with oui.csv, mam.csv and oui36.csv>
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var registries = []struct {
+	file string
+	url  string
+}{
+	{"oui.csv", "https://standards-oui.ieee.org/oui/oui.csv"},
+	{"mam.csv", "https://standards-oui.ieee.org/oui28/mam.csv"},
+	{"oui36.csv", "https://standards-oui.ieee.org/oui36/oui36.csv"},
+}
+
+// organizations are kept whatever the indicators say, they make KVM boards
+// or the SoCs in them. Only the Raspberry Pi and GL blocks are in the
+// committed extract.
+var organizations = []string{
+	"Raspberry Pi",
+	"GL Technologies",
+	"Rockchip",
+	"Sipeed",
+	"TinyPilot",
+	"JetKVM",
+}
+
+var hexDigit = regexp.MustCompile(`[^0-9A-F]`)
+
+func main() {
+	src := flag.String("src", "", "directory with the registry CSVs, they're downloaded when not set")
+	indicatorsPath := flag.String("i", "../../indicators.yaml", "indicators file to take MAC prefixes and organizations from")
+	out := flag.String("o", "oui_seed.csv", "file to write")
+	flag.Parse()
+
+	orgs, prefixes := indicatorMACs(*indicatorsPath)
+	orgs = append(orgs, organizations...)
+
+	rows := [][]string{}
+	for _, reg := range registries {
+		r, err := openRegistry(*src, reg.file, reg.url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows = append(rows, keepRows(r, orgs, prefixes)...)
+		r.Close()
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i][0] != rows[j][0] {
+			return rows[i][0] < rows[j][0]
+		}
+		return rows[i][1] < rows[j][1]
+	})
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Registry", "Assignment", "Organization Name", "Organization Address"})
+	w.WriteAll(rows)
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d assignments to %s", len(rows), *out)
+}
+
+// indicatorMACs returns the organization names and prefixes (as hex
+// digits) of the mac_addresses rules, mask rules are left out
+func indicatorMACs(path string) ([]string, []string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var config struct {
+		Network struct {
+			MACAddresses map[string]struct {
+				Prefixes []struct {
+					Prefix       string `yaml:"prefix"`
+					Organization string `yaml:"organization"`
+				} `yaml:"prefixes"`
+			} `yaml:"mac_addresses"`
+		} `yaml:"network"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		log.Fatal(err)
+	}
+	orgs, prefixes := []string{}, []string{}
+	for _, group := range config.Network.MACAddresses {
+		for _, p := range group.Prefixes {
+			if p.Organization != "" {
+				orgs = append(orgs, p.Organization)
+			}
+			if p.Prefix != "" && !strings.Contains(p.Prefix, "/") {
+				prefixes = append(prefixes, hexDigit.ReplaceAllString(strings.ToUpper(p.Prefix), ""))
+			}
+		}
+	}
+	return orgs, prefixes
+}
+
+func openRegistry(src string, file string, url string) (io.ReadCloser, error) {
+	if src != "" {
+		return os.Open(filepath.Join(src, file))
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// keepRows returns the Registry, Assignment, Organization Name rows whose
+// organization contains one of orgs or whose assignment overlaps a prefix.
+// The address column is dropped to keep the binary small.
+func keepRows(in io.Reader, orgs []string, prefixes []string) [][]string {
+	c := csv.NewReader(in)
+	c.FieldsPerRecord = -1
+	rows := [][]string{}
+	header, err := c.Read()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(header) < 3 {
+		log.Fatalf("unexpected registry header %v", header)
+	}
+	for {
+		row, err := c.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(row) < 3 {
+			continue
+		}
+		assignment := strings.ToUpper(strings.TrimSpace(row[1]))
+		org := strings.TrimSpace(row[2])
+		keep := false
+		for _, o := range orgs {
+			if strings.Contains(strings.ToLower(org), strings.ToLower(o)) {
+				keep = true
+			}
+		}
+		for _, p := range prefixes {
+			if len(p) >= 6 && (strings.HasPrefix(p, assignment) || strings.HasPrefix(assignment, p)) {
+				keep = true
+			}
+		}
+		if keep {
+			rows = append(rows, []string{strings.TrimSpace(row[0]), assignment, org, ""})
+		}
+	}
+}
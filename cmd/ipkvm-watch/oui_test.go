@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestOUIRegistryLookup(t *testing.T) {
+	r := loadOUIRegistry([]string{"testdata/oui_blocks.csv"})
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		// 36 bit MA-S block inside the registration authority's MA-L
+		{"70:b3:d5:ab:c1:23", "Example MA-S Organization"},
+		{"70:b3:d5:ab:d1:23", "IEEE Registration Authority"},
+		// 28 bit MA-M block inside a MA-L
+		{"04:c9:8b:1a:bc:de", "Example MA-M Organization"},
+		{"04-C9-8B-2A-BC-DE", "Example MA-L Organization"},
+		// from the embedded seed
+		{"b8:27:eb:12:34:56", "Raspberry Pi Foundation"},
+		{"00:00:00:00:00:01", ""},
+		{"not a mac", ""},
+	}
+	for _, tt := range tests {
+		if got := r.Lookup(tt.mac); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+}
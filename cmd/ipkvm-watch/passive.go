@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	llmnrGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 252), Port: 5355}
+	llmnrGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::1:3"), Port: 5355}
+	nbnsAddr       = &net.UDPAddr{IP: net.IPv4zero, Port: 137}
+)
+
+// nbnsTypeNB is the NBNS general name service record (RFC 1002)
+const nbnsTypeNB = 0x20
+
+// HeardRecord is a name the passive listener heard announced, answered or
+// asked for, with how often and when
+type HeardRecord struct {
+	Protocol  string // mdns, llmnr or nbns
+	Type      string // A, AAAA, PTR, SRV, TXT, NB or query
+	Name      string
+	Value     string // address, PTR or SRV target, TXT strings
+	Source    string
+	Interface string
+	Family    string
+	Count     int
+	FirstSeen string
+	LastSeen  string
+}
+
+// heardLog dedups what the listener heard, keeping the order it came in
+type heardLog struct {
+	mu      sync.Mutex
+	records map[HeardRecord]*HeardRecord
+	order   []HeardRecord
+}
+
+func (h *heardLog) add(r HeardRecord) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if seen, ok := h.records[r]; ok {
+		seen.Count++
+		seen.LastSeen = now
+		return
+	}
+	key := r
+	r.Count, r.FirstSeen, r.LastSeen = 1, now, now
+	h.records[key] = &r
+	h.order = append(h.order, key)
+	log.Debug().
+		Str("protocol", r.Protocol).
+		Str("type", r.Type).
+		Str("name", r.Name).
+		Str("value", r.Value).
+		Str("src", r.Source).
+		Msg("Heard name")
+}
+
+func (h *heardLog) list() []HeardRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	heard := []HeardRecord{}
+	for _, key := range h.order {
+		heard = append(heard, *h.records[key])
+	}
+	return heard
+}
+
+// dnsHeardRecords turns an mDNS or LLMNR packet into records: the
+// questions of queries and the address, PTR, SRV and TXT records of
+// answers and announcements
+func dnsHeardRecords(protocol string, msg dnsmessage.Message, base HeardRecord) []HeardRecord {
+	records := []HeardRecord{}
+	if !msg.Response {
+		for _, q := range msg.Questions {
+			r := base
+			r.Protocol, r.Type, r.Name = protocol, "query", mdnsName(q.Name)
+			records = append(records, r)
+		}
+		return records
+	}
+	for _, rr := range slices.Concat(msg.Answers, msg.Additionals) {
+		r := base
+		r.Protocol, r.Name = protocol, mdnsName(rr.Header.Name)
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			r.Type, r.Value = "A", withLinkZone(netip.AddrFrom4(body.A), base.Interface).String()
+		case *dnsmessage.AAAAResource:
+			r.Type, r.Value = "AAAA", withLinkZone(netip.AddrFrom16(body.AAAA), base.Interface).String()
+		case *dnsmessage.PTRResource:
+			r.Type, r.Value = "PTR", strings.TrimSuffix(body.PTR.String(), ".")
+		case *dnsmessage.SRVResource:
+			r.Type, r.Value = "SRV", fmt.Sprintf("%s:%d", mdnsName(body.Target), body.Port)
+		case *dnsmessage.TXTResource:
+			r.Type, r.Value = "TXT", strings.Join(body.TXT, " ")
+		default:
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// decodeNetBIOSName undoes the first-level encoding of a NetBIOS name,
+// every byte is written as two letters from A to P. The 16th byte is the
+// service suffix and is dropped, ex: "FHEPFCELEHFCEPFFFACACACACACACAAA" ->
+// WORKGROUP.
+func decodeNetBIOSName(label string) (string, bool) {
+	if len(label) != 32 {
+		return "", false
+	}
+	b := make([]byte, 16)
+	for i := range b {
+		hi, lo := label[2*i]-'A', label[2*i+1]-'A'
+		if hi > 15 || lo > 15 {
+			return "", false
+		}
+		b[i] = hi<<4 | lo
+	}
+	return strings.TrimRight(string(b[:15]), " \x00"), true
+}
+
+// nbnsHeardRecords turns an NBNS packet into records: names queried for and
+// names registered or answered with their addresses
+func nbnsHeardRecords(msg dnsmessage.Message, base HeardRecord) []HeardRecord {
+	netbiosName := func(name dnsmessage.Name) (string, bool) {
+		label, _, _ := strings.Cut(name.String(), ".")
+		n, ok := decodeNetBIOSName(strings.ToUpper(label))
+		if !ok || n == "" || n == "*" {
+			return "", false
+		}
+		return strings.ToLower(n), true
+	}
+	records := []HeardRecord{}
+	// registrations repeat the question name in an additional record with
+	// the address, only name queries (opcode 0) are kept from the questions
+	for _, q := range msg.Questions {
+		name, ok := netbiosName(q.Name)
+		if !ok || msg.Response || msg.OpCode != 0 {
+			continue
+		}
+		r := base
+		r.Protocol, r.Type, r.Name = "nbns", "query", name
+		records = append(records, r)
+	}
+	for _, rr := range slices.Concat(msg.Answers, msg.Additionals) {
+		name, ok := netbiosName(rr.Header.Name)
+		if !ok || rr.Header.Type != nbnsTypeNB {
+			continue
+		}
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		// each entry is 2 bytes of flags and an IPv4 address
+		for data := body.Data; len(data) >= 6; data = data[6:] {
+			r := base
+			r.Protocol, r.Type, r.Name = "nbns", "NB", name
+			r.Value = netip.AddrFrom4([4]byte(data[2:6])).String()
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// listenPassive records every name announced over mDNS, LLMNR and NBNS for
+// the given time without sending anything. ifaceNames limits the
+// interfaces the multicast groups are joined on.
+func listenPassive(duration time.Duration, ifaceNames []string) ([]HeardRecord, error) {
+	ifaces, err := mdnsInterfaces(ifaceNames)
+	if err != nil {
+		return nil, err
+	}
+	ifaceNamesByIndex := map[int]string{}
+	for _, ifi := range ifaces {
+		ifaceNamesByIndex[ifi.Index] = ifi.Name
+	}
+	heard := &heardLog{records: map[HeardRecord]*HeardRecord{}}
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+
+	groups := map[string][]*net.UDPAddr{
+		"mdns":  {mdnsGroupIPv4, mdnsGroupIPv6},
+		"llmnr": {llmnrGroupIPv4, llmnrGroupIPv6},
+	}
+	listening := 0
+	for _, protocol := range slices.Sorted(maps.Keys(groups)) {
+		for _, group := range groups[protocol] {
+			conn, _, err := listenGroup(group, ifaces)
+			if err != nil {
+				log.Warn().Err(err).Str("group", group.String()).Msg("Failed to listen for " + protocol)
+				continue
+			}
+			defer conn.Close()
+			conn.setDeadline(deadline)
+			listening++
+			wg.Add(1)
+			go func(protocol string, conn mdnsConn) {
+				defer wg.Done()
+				buf := make([]byte, 9000)
+				for {
+					n, ifIndex, src, err := conn.receive(buf)
+					if err != nil {
+						if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+							log.Error().Err(err).Str("protocol", protocol).Msg("Failed to read packets")
+						}
+						return
+					}
+					var msg dnsmessage.Message
+					if err := msg.Unpack(buf[:n]); err != nil {
+						continue
+					}
+					base := HeardRecord{Interface: ifaceNamesByIndex[ifIndex], Family: conn.family()}
+					if udp, ok := src.(*net.UDPAddr); ok {
+						base.Source = udp.IP.String()
+						if base.Interface == "" {
+							base.Interface = udp.Zone
+						}
+					}
+					for _, r := range dnsHeardRecords(protocol, msg, base) {
+						heard.add(r)
+					}
+				}
+			}(protocol, conn)
+		}
+	}
+
+	// NBNS is broadcast, binding the port is enough
+	if nbns, err := net.ListenUDP("udp4", nbnsAddr); err != nil {
+		log.Warn().Err(err).Msg("Failed to listen for nbns")
+	} else {
+		defer nbns.Close()
+		nbns.SetReadDeadline(deadline)
+		listening++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 1500)
+			for {
+				n, src, err := nbns.ReadFromUDP(buf)
+				if err != nil {
+					if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+						log.Error().Err(err).Str("protocol", "nbns").Msg("Failed to read packets")
+					}
+					return
+				}
+				var msg dnsmessage.Message
+				if err := msg.Unpack(buf[:n]); err != nil {
+					continue
+				}
+				base := HeardRecord{Source: src.IP.String(), Family: "ipv4"}
+				for _, r := range nbnsHeardRecords(msg, base) {
+					heard.add(r)
+				}
+			}
+		}()
+	}
+	if listening == 0 {
+		return nil, fmt.Errorf("failed to listen for mdns, llmnr or nbns")
+	}
+	log.Info().Dur("duration", duration).Msg("Listening for mDNS, LLMNR and NBNS names")
+	wg.Wait()
+	return heard.list(), nil
+}
+
+// claimedNames are the names a record says a host has: its owner name and
+// the PTR or SRV target. Queries don't count since asking for a name says
+// nothing about who has it.
+func (r HeardRecord) claimedNames() []string {
+	switch r.Type {
+	case "query":
+		return nil
+	case "PTR":
+		return []string{r.Name, canonicalName(r.Value)}
+	case "SRV":
+		target, _, _ := strings.Cut(r.Value, ":")
+		return []string{r.Name, target}
+	}
+	return []string{r.Name}
+}
+
+// heardNames lists every name hosts claimed, with the addresses they
+// claimed for it
+func heardNames(heard []HeardRecord) []observedName {
+	names := []observedName{}
+	for _, r := range heard {
+		for _, name := range r.claimedNames() {
+			if name == "" {
+				continue
+			}
+			n := observedName{Name: name, Method: "passive", Source: r.Source, Interface: r.Interface, Family: r.Family}
+			if r.Name == name && (r.Type == "A" || r.Type == "AAAA" || r.Type == "NB") {
+				if addr, err := netip.ParseAddr(r.Value); err == nil {
+					n.Addrs = append(n.Addrs, addr)
+				}
+			}
+			names = append(names, n)
+		}
+	}
+	return names
+}
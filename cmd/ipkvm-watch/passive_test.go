@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// packed round-trips a message through the wire format, like the listener
+// gets it
+func packed(t *testing.T, msg dnsmessage.Message) dnsmessage.Message {
+	t.Helper()
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out dnsmessage.Message
+	if err := out.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func heardStrings(records []HeardRecord) []string {
+	s := []string{}
+	for _, r := range records {
+		s = append(s, fmt.Sprintf("%s %s %s %s", r.Protocol, r.Type, r.Name, r.Value))
+	}
+	return s
+}
+
+func TestDNSHeardRecordsAnnouncement(t *testing.T) {
+	header := func(name string, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		// cache flush bit set like avahi does for unique records
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET | 1<<15, TTL: 120}
+	}
+	msg := packed(t, dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{Header: header("_https._tcp.local.", dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("PiKVM._https._tcp.local.")}},
+			{Header: header("PiKVM._https._tcp.local.", dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Port: 443, Target: dnsmessage.MustNewName("PiKVM.local.")}},
+			{Header: header("PiKVM._https._tcp.local.", dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"model=v4", "path=/"}}},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: header("PiKVM.local.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}}},
+			{Header: header("PiKVM.local.", dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0xfe, 0x80, 15: 1}}},
+			{Header: header("PiKVM.local.", dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 0x00, 15: 1}}},
+			{Header: header("PiKVM.local.", dnsmessage.Type(47)), Body: &dnsmessage.UnknownResource{Type: dnsmessage.Type(47), Data: []byte{0xc0, 0x0c, 0, 4, 0x40, 0, 0, 8}}},
+		},
+	})
+	base := HeardRecord{Source: "192.168.1.20", Interface: "eth0", Family: "ipv4"}
+	got := heardStrings(dnsHeardRecords("mdns", msg, base))
+	want := []string{
+		"mdns PTR _https._tcp.local PiKVM._https._tcp.local",
+		"mdns SRV pikvm._https._tcp.local pikvm.local:443",
+		"mdns TXT pikvm._https._tcp.local model=v4 path=/",
+		"mdns A pikvm.local 192.168.1.20",
+		"mdns AAAA pikvm.local fe80::1%eth0",
+		"mdns AAAA pikvm.local fd00::1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	names := heardNames(dnsHeardRecords("mdns", msg, base))
+	addrs := map[string][]string{}
+	for _, n := range names {
+		for _, addr := range n.Addrs {
+			addrs[n.Name] = append(addrs[n.Name], addr.String())
+		}
+		if n.Method != "passive" || n.Source != "192.168.1.20" {
+			t.Errorf("got %+v", n)
+		}
+	}
+	if !slices.Equal(addrs["pikvm.local"], []string{"192.168.1.20", "fe80::1%eth0", "fd00::1"}) || len(addrs) != 1 {
+		t.Errorf("got addresses %v", addrs)
+	}
+	if !slices.ContainsFunc(names, func(n observedName) bool { return n.Name == "pikvm._https._tcp.local" }) {
+		t.Errorf("PTR target missing from %+v", names)
+	}
+}
+
+func TestDNSHeardRecordsLLMNRQuery(t *testing.T) {
+	msg := packed(t, dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 0x1f2e},
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName("glkvm."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+	})
+	records := dnsHeardRecords("llmnr", msg, HeardRecord{Source: "192.168.8.100", Family: "ipv4"})
+	if got := heardStrings(records); !slices.Equal(got, []string{"llmnr query glkvm "}) {
+		t.Errorf("got %q", got)
+	}
+	// asking for a name doesn't claim it
+	if names := heardNames(records); len(names) != 0 {
+		t.Errorf("got names %+v from a query", names)
+	}
+}
+
+func TestNBNSHeardRecords(t *testing.T) {
+	// GLKVM<20> and WORKGROUP<00>
+	glkvm := dnsmessage.MustNewName("EHEMELFGENCACACACACACACACACACACA.")
+	workgroup := dnsmessage.MustNewName("FHEPFCELEHFCEPFFFACACACACACACAAA.")
+	nb := func(name dnsmessage.Name, data ...byte) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: nbnsTypeNB, Class: dnsmessage.ClassINET, TTL: 300000},
+			Body:   &dnsmessage.UnknownResource{Type: nbnsTypeNB, Data: data},
+		}
+	}
+	question := func(name dnsmessage.Name) []dnsmessage.Question {
+		return []dnsmessage.Question{{Name: name, Type: nbnsTypeNB, Class: dnsmessage.ClassINET}}
+	}
+	tests := []struct {
+		name string
+		msg  dnsmessage.Message
+		want []string
+	}{
+		{"name query", dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 0x8a01, RecursionDesired: true},
+			Questions: question(workgroup),
+		}, []string{"nbns query workgroup "}},
+		// registration (opcode 5), the question only repeats the record
+		{"registration", dnsmessage.Message{
+			Header:      dnsmessage.Header{ID: 0x8a02, OpCode: 5, RecursionDesired: true},
+			Questions:   question(glkvm),
+			Additionals: []dnsmessage.Resource{nb(glkvm, 0x00, 0x00, 192, 168, 8, 1)},
+		}, []string{"nbns NB glkvm 192.168.8.1"}},
+		// a multihomed answer has a 6 byte entry per address, a short tail is ignored
+		{"answer", dnsmessage.Message{
+			Header:  dnsmessage.Header{ID: 0x8a01, Response: true, Authoritative: true},
+			Answers: []dnsmessage.Resource{nb(glkvm, 0x00, 0x00, 192, 168, 8, 1, 0x00, 0x00, 10, 0, 0, 5, 0x00, 0x00, 10)},
+		}, []string{"nbns NB glkvm 192.168.8.1", "nbns NB glkvm 10.0.0.5"}},
+		{"wildcard", dnsmessage.Message{
+			Questions: question(dnsmessage.MustNewName("CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA.")),
+		}, []string{}},
+	}
+	for _, tt := range tests {
+		got := heardStrings(nbnsHeardRecords(packed(t, tt.msg), HeardRecord{Source: "192.168.8.1", Family: "ipv4"}))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeNetBIOSName(t *testing.T) {
+	tests := []struct {
+		label string
+		name  string
+		ok    bool
+	}{
+		{"FHEPFCELEHFCEPFFFACACACACACACAAA", "WORKGROUP", true},
+		{"EHEMELFGENCACACACACACACACACACACA", "GLKVM", true},
+		{"FHEPFCELEHFCEPFFFACACACACACACA", "", false},
+		{"FHEPFCELEHFCEPFFFACACACACACACAZA", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := decodeNetBIOSName(tt.label)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("%s: got %q %v, want %q %v", tt.label, name, ok, tt.name, tt.ok)
+		}
+	}
+}
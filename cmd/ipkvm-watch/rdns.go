@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// rdnsTimeout bounds each unicast PTR lookup
+var rdnsTimeout = 2 * time.Second
+
+// reverseName writes the PTR name of an address, ex: 192.168.8.1 ->
+// 1.8.168.192.in-addr.arpa, IPv6 addresses are written nibble by nibble
+// under ip6.arpa
+func reverseName(addr netip.Addr) string {
+	addr = addr.Unmap()
+	b := addr.AsSlice()
+	labels := []string{}
+	if addr.Is4() {
+		for i := len(b) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%d", b[i]))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa"
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", b[i]&0x0f), fmt.Sprintf("%x", b[i]>>4))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
+
+// reverseLookup asks the system resolver and, unless mdns is false, the
+// mDNS responders on every interface for the names of the addresses.
+// Link-local addresses are only asked over mDNS.
+func reverseLookup(ips []string, ifaceNames []string, mdns bool) []observedName {
+	addrs := []netip.Addr{}
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	names := []observedName{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10)
+	for _, addr := range addrs {
+		if addr.IsLinkLocalUnicast() {
+			continue
+		}
+		wg.Add(1)
+		go func(addr netip.Addr) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+			defer cancel()
+			ptrs, err := net.DefaultResolver.LookupAddr(ctx, addr.WithZone("").String())
+			if err != nil {
+				log.Debug().Err(err).Str("ip", addr.String()).Msg("No reverse DNS name")
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ptr := range ptrs {
+				names = append(names, observedName{Name: canonicalName(ptr), Method: "rdns", Addrs: []netip.Addr{addr}})
+			}
+		}(addr)
+	}
+
+	if mdns {
+		mdnsNames, err := reverseLookupMDNS(addrs, ifaceNames)
+		if err != nil {
+			log.Error().Err(err).Msg("mDNS reverse lookup failed")
+		}
+		mu.Lock()
+		names = append(names, mdnsNames...)
+		mu.Unlock()
+	}
+	wg.Wait()
+	for _, n := range names {
+		log.Debug().Str("ip", n.Addrs[0].String()).Str("name", n.Name).Str("src", n.Source).Msg("Reverse DNS name")
+	}
+	return names
+}
+
+// reverseLookupMDNS multicasts the PTR questions of every address and
+// collects the answers with the responder they came from
+func reverseLookupMDNS(addrs []netip.Addr, ifaceNames []string) ([]observedName, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	ifaces, err := mdnsInterfaces(ifaceNames)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]netip.Addr{}
+	reverse := []string{}
+	for _, addr := range addrs {
+		name := reverseName(addr)
+		byName[name] = addr
+		reverse = append(reverse, name)
+	}
+	questions, err := mdnsQuestions(reverse, dnsmessage.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	names := []observedName{}
+	seen := map[[2]string]bool{}
+	err = mdnsQuery(questions, ifaces, func(pkt mdnsPacket) {
+		for _, rr := range mdnsResources(pkt.msg) {
+			body, ok := rr.Body.(*dnsmessage.PTRResource)
+			if !ok {
+				continue
+			}
+			addr, ok := byName[mdnsName(rr.Header.Name)]
+			if !ok {
+				continue
+			}
+			name := mdnsName(body.PTR)
+			key := [2]string{addr.String(), name}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			names = append(names, observedName{
+				Name:      name,
+				Method:    "rdns",
+				Source:    pkt.Source,
+				Interface: pkt.Interface,
+				Family:    pkt.Family,
+				Addrs:     []netip.Addr{addr},
+			})
+		}
+	})
+	return names, err
+}
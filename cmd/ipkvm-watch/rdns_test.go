@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.168.8.1":               "1.8.168.192.in-addr.arpa",
+		"10.0.0.254":                "254.0.0.10.in-addr.arpa",
+		"::ffff:192.168.8.1":        "1.8.168.192.in-addr.arpa",
+		"2001:db8::567:89ab":        "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+		"fe80::9683:c4ff:feae:ac2a": "a.2.c.a.e.a.e.f.f.f.4.c.3.8.6.9.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa",
+	}
+	for ip, want := range tests {
+		if got := reverseName(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: got %s, want %s", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A minimal read-only parser for Windows registry hive files (regf). It
+// only understands what is needed to walk keys and read values, see
+// https://github.com/msuhanov/regf/blob/master/Windows%20registry%20file%20format%20specification.md
+
+const (
+	regfBaseBlockSize = 4096
+	regKeyCompName    = 0x0020
+	regValueCompName  = 0x0001
+	regDataInline     = 0x80000000
+
+	regSZ       = 1
+	regExpandSZ = 2
+	regBinary   = 3
+	regDWORD    = 4
+	regMultiSZ  = 7
+	regFiletime = 0x10
+
+	// device properties (Properties\{guid}\nnnn) store a DEVPROP_TYPE
+	// with the high word set
+	regDevPropMask = 0xffff0000
+	devpropString  = 0x12
+)
+
+// RegistryHive is a hive file loaded into memory
+type RegistryHive struct {
+	data       []byte
+	rootOffset uint32
+}
+
+// RegistryKey is an nk record
+type RegistryKey struct {
+	hive      *RegistryHive
+	Name      string
+	LastWrite time.Time
+	subkeys   uint32
+	subList   uint32
+	values    uint32
+	valueList uint32
+}
+
+// RegistryValue is a vk record
+type RegistryValue struct {
+	Name string
+	Type uint32
+	Data []byte
+}
+
+func openRegistryHive(path string) (*RegistryHive, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegistryHive(b)
+}
+
+func parseRegistryHive(b []byte) (*RegistryHive, error) {
+	if len(b) < regfBaseBlockSize || !bytes.Equal(b[:4], []byte("regf")) {
+		return nil, fmt.Errorf("not a registry hive (missing regf signature)")
+	}
+	return &RegistryHive{
+		data:       b,
+		rootOffset: binary.LittleEndian.Uint32(b[0x24:0x28]),
+	}, nil
+}
+
+// cell returns the data of the cell at a hive bins offset, without the size
+func (h *RegistryHive) cell(offset uint32) ([]byte, error) {
+	start := regfBaseBlockSize + int(offset)
+	if offset == 0xffffffff || start+4 > len(h.data) {
+		return nil, fmt.Errorf("cell offset 0x%x out of range", offset)
+	}
+	size := int32(binary.LittleEndian.Uint32(h.data[start : start+4]))
+	// allocated cells have a negative size
+	if size < 0 {
+		size = -size
+	}
+	end := start + int(size)
+	if size < 4 || end > len(h.data) {
+		return nil, fmt.Errorf("cell at 0x%x has a bad size", offset)
+	}
+	return h.data[start+4 : end], nil
+}
+
+// Root returns the hive's root key
+func (h *RegistryHive) Root() (*RegistryKey, error) {
+	return h.key(h.rootOffset)
+}
+
+func (h *RegistryHive) key(offset uint32) (*RegistryKey, error) {
+	c, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 76 || !bytes.Equal(c[:2], []byte("nk")) {
+		return nil, fmt.Errorf("cell at 0x%x is not a key", offset)
+	}
+	flags := binary.LittleEndian.Uint16(c[2:4])
+	nameLen := int(binary.LittleEndian.Uint16(c[72:74]))
+	if 76+nameLen > len(c) {
+		return nil, fmt.Errorf("key at 0x%x has a bad name length", offset)
+	}
+	return &RegistryKey{
+		hive:      h,
+		Name:      decodeRegistryName(c[76:76+nameLen], flags&regKeyCompName != 0),
+		LastWrite: filetimeToTime(binary.LittleEndian.Uint64(c[4:12])),
+		subkeys:   binary.LittleEndian.Uint32(c[20:24]),
+		subList:   binary.LittleEndian.Uint32(c[28:32]),
+		values:    binary.LittleEndian.Uint32(c[36:40]),
+		valueList: binary.LittleEndian.Uint32(c[40:44]),
+	}, nil
+}
+
+// Subkeys returns the key's children
+func (k *RegistryKey) Subkeys() ([]*RegistryKey, error) {
+	if k.subkeys == 0 {
+		return []*RegistryKey{}, nil
+	}
+	offsets, err := k.hive.subkeyOffsets(k.subList, 0)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*RegistryKey{}
+	for _, offset := range offsets {
+		sub, err := k.hive.key(offset)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sub)
+	}
+	return keys, nil
+}
+
+// subkeyOffsets reads lf/lh/li lists, and ri lists of those
+func (h *RegistryHive) subkeyOffsets(offset uint32, depth int) ([]uint32, error) {
+	if depth > 4 {
+		return nil, fmt.Errorf("subkey lists nested too deep")
+	}
+	c, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 4 {
+		return nil, fmt.Errorf("subkey list at 0x%x too short", offset)
+	}
+	count := int(binary.LittleEndian.Uint16(c[2:4]))
+	offsets := []uint32{}
+	switch string(c[:2]) {
+	case "lf", "lh":
+		// offset + name hint
+		for i := 0; i < count && 4+i*8+4 <= len(c); i++ {
+			offsets = append(offsets, binary.LittleEndian.Uint32(c[4+i*8:]))
+		}
+	case "li":
+		for i := 0; i < count && 4+i*4+4 <= len(c); i++ {
+			offsets = append(offsets, binary.LittleEndian.Uint32(c[4+i*4:]))
+		}
+	case "ri":
+		for i := 0; i < count && 4+i*4+4 <= len(c); i++ {
+			sub, err := h.subkeyOffsets(binary.LittleEndian.Uint32(c[4+i*4:]), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			offsets = append(offsets, sub...)
+		}
+	default:
+		return nil, fmt.Errorf("unknown subkey list %q at 0x%x", c[:2], offset)
+	}
+	return offsets, nil
+}
+
+// Subkey returns the child with the given name (case insensitive)
+func (k *RegistryKey) Subkey(name string) (*RegistryKey, error) {
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subkeys {
+		if strings.EqualFold(sub.Name, name) {
+			return sub, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s has no subkey %s", k.Name, name)
+}
+
+// OpenPath follows a backslash separated path of subkeys
+func (k *RegistryKey) OpenPath(path string) (*RegistryKey, error) {
+	key := k
+	for _, part := range strings.Split(path, `\`) {
+		if part == "" {
+			continue
+		}
+		sub, err := key.Subkey(part)
+		if err != nil {
+			return nil, err
+		}
+		key = sub
+	}
+	return key, nil
+}
+
+// Values returns all of the key's values
+func (k *RegistryKey) Values() ([]RegistryValue, error) {
+	values := []RegistryValue{}
+	if k.values == 0 {
+		return values, nil
+	}
+	list, err := k.hive.cell(k.valueList)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(k.values) && i*4+4 <= len(list); i++ {
+		v, err := k.hive.value(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (h *RegistryHive) value(offset uint32) (RegistryValue, error) {
+	c, err := h.cell(offset)
+	if err != nil {
+		return RegistryValue{}, err
+	}
+	if len(c) < 20 || !bytes.Equal(c[:2], []byte("vk")) {
+		return RegistryValue{}, fmt.Errorf("cell at 0x%x is not a value", offset)
+	}
+	nameLen := int(binary.LittleEndian.Uint16(c[2:4]))
+	size := binary.LittleEndian.Uint32(c[4:8])
+	dataOffset := binary.LittleEndian.Uint32(c[8:12])
+	flags := binary.LittleEndian.Uint16(c[16:18])
+	if 20+nameLen > len(c) {
+		return RegistryValue{}, fmt.Errorf("value at 0x%x has a bad name length", offset)
+	}
+	v := RegistryValue{
+		Name: decodeRegistryName(c[20:20+nameLen], flags&regValueCompName != 0),
+		Type: binary.LittleEndian.Uint32(c[12:16]),
+	}
+	if size&regDataInline != 0 {
+		// small values are stored in the offset field itself
+		size &^= regDataInline
+		v.Data = c[8 : 8+min(size, 4)]
+		return v, nil
+	}
+	data, err := h.cell(dataOffset)
+	if err != nil {
+		return v, err
+	}
+	if bytes.HasPrefix(data, []byte("db")) {
+		return v, fmt.Errorf("big data values are not supported")
+	}
+	if int(size) > len(data) {
+		return v, fmt.Errorf("value %s data is truncated", v.Name)
+	}
+	v.Data = data[:size]
+	return v, nil
+}
+
+// Value returns the value with the given name, "" is the default value
+func (k *RegistryKey) Value(name string) (RegistryValue, bool) {
+	values, err := k.Values()
+	if err != nil {
+		return RegistryValue{}, false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v.Name, name) {
+			return v, true
+		}
+	}
+	return RegistryValue{}, false
+}
+
+// String decodes REG_SZ, REG_EXPAND_SZ and the first REG_MULTI_SZ entry
+func (v RegistryValue) String() string {
+	switch v.valueType() {
+	case regSZ, regExpandSZ, regMultiSZ, devpropString:
+		s := decodeUTF16(v.Data)
+		if i := strings.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return s
+	case regDWORD:
+		if len(v.Data) >= 4 {
+			return fmt.Sprint(binary.LittleEndian.Uint32(v.Data))
+		}
+	}
+	return ""
+}
+
+// Uint32 decodes a REG_DWORD
+func (v RegistryValue) Uint32() (uint32, bool) {
+	if v.Type != regDWORD || len(v.Data) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(v.Data), true
+}
+
+// Time decodes a FILETIME stored as REG_FILETIME or REG_BINARY
+func (v RegistryValue) Time() (time.Time, bool) {
+	if t := v.valueType(); (t != regFiletime && t != regBinary) || len(v.Data) != 8 {
+		return time.Time{}, false
+	}
+	return filetimeToTime(binary.LittleEndian.Uint64(v.Data)), true
+}
+
+// valueType strips the device property marker from the type
+func (v RegistryValue) valueType() uint32 {
+	if v.Type&regDevPropMask == regDevPropMask {
+		return v.Type &^ regDevPropMask
+	}
+	return v.Type
+}
+
+func decodeRegistryName(b []byte, ascii bool) string {
+	if ascii {
+		// compressed names are latin-1
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	}
+	return decodeUTF16(b)
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// filetimeToTime converts 100ns intervals since 1601-01-01 to a time
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epochDiff = 116444736000000000 // 1601 -> 1970 in 100ns
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(int64(ft/10000000), int64(ft%10000000)*100).UTC()
+}
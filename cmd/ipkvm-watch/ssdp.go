@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/ipv4"
+)
+
+// ssdpGroupIPv4 is where M-SEARCH is sent, tests point it at a local responder
+var ssdpGroupIPv4 = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpHTTPClient fetches the LOCATION descriptions
+var ssdpHTTPClient = &http.Client{Timeout: 3 * time.Second}
+
+// ssdpSearchTime is how long the M-SEARCH answers are collected for, the
+// search is repeated every ssdpSearchInterval until then
+var (
+	ssdpSearchTime     = 3 * time.Second
+	ssdpSearchInterval = time.Second
+)
+
+// ssdpSearchTargets are searched for in turn, some devices only answer
+// upnp:rootdevice
+var ssdpSearchTargets = []string{"ssdp:all", "upnp:rootdevice"}
+
+// ssdpMaxDescription caps the size of a device description
+const ssdpMaxDescription = 1 << 20
+
+// SSDPDevice is a UPnP root device that answered the M-SEARCH, with what
+// its LOCATION description says about it
+type SSDPDevice struct {
+	Location         string // ex: http://192.168.8.1:49152/rootDesc.xml
+	Server           string // ex: Linux/5.10 UPnP/1.0 GL.iNet/4.0
+	ST               string
+	USN              string
+	Source           string
+	Interface        string
+	DeviceType       string // ex: urn:schemas-upnp-org:device:Basic:1
+	FriendlyName     string
+	Manufacturer     string
+	ManufacturerURL  string
+	ModelName        string
+	ModelNumber      string
+	ModelDescription string
+	SerialNumber     string
+	UDN              string
+	PresentationURL  string
+}
+
+type SSDPFinding struct {
+	Vendor     string
+	Confidence string
+	Field      string // friendly_name, manufacturer, model_name or serial_number
+	Value      string
+	Device     SSDPDevice
+	Reference  string
+}
+
+// upnpDescription is the part of a UPnP device description we look at, ex:
+//
+//	<root xmlns="urn:schemas-upnp-org:device-1-0">
+//	  <device>
+//	    <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+//	    <friendlyName>GLKVM</friendlyName>
+//	    <manufacturer>GL Technologies (Hong Kong) Limited</manufacturer>
+//	    <modelName>RM1</modelName>
+//	    <serialNumber>94:83:c4:ae:ac:2a</serialNumber>
+//	    <UDN>uuid:...</UDN>
+//	  </device>
+//	</root>
+type upnpDescription struct {
+	Device struct {
+		DeviceType       string `xml:"deviceType"`
+		FriendlyName     string `xml:"friendlyName"`
+		Manufacturer     string `xml:"manufacturer"`
+		ManufacturerURL  string `xml:"manufacturerURL"`
+		ModelName        string `xml:"modelName"`
+		ModelNumber      string `xml:"modelNumber"`
+		ModelDescription string `xml:"modelDescription"`
+		SerialNumber     string `xml:"serialNumber"`
+		UDN              string `xml:"UDN"`
+		PresentationURL  string `xml:"presentationURL"`
+	} `xml:"device"`
+}
+
+func buildMSearch(st string) []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpGroupIPv4.String() + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + st + "\r\n\r\n")
+}
+
+// parseSSDPResponse reads the headers of an M-SEARCH answer, ex:
+//
+//	HTTP/1.1 200 OK
+//	CACHE-CONTROL: max-age=1800
+//	LOCATION: http://192.168.8.1:49152/rootDesc.xml
+//	SERVER: Linux/5.10 UPnP/1.0 GL.iNet/4.0
+//	ST: upnp:rootdevice
+//	USN: uuid:...::upnp:rootdevice
+func parseSSDPResponse(b []byte) (SSDPDevice, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return SSDPDevice{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return SSDPDevice{}, fmt.Errorf("unexpected SSDP status: %s", resp.Status)
+	}
+	d := SSDPDevice{
+		Location: strings.TrimSpace(resp.Header.Get("Location")),
+		Server:   resp.Header.Get("Server"),
+		ST:       resp.Header.Get("St"),
+		USN:      resp.Header.Get("Usn"),
+	}
+	if d.Location == "" {
+		return d, fmt.Errorf("SSDP answer without a LOCATION")
+	}
+	return d, nil
+}
+
+// searchSSDP multicasts M-SEARCH on every interface and collects one device
+// per LOCATION. ifaceNames limits the interfaces used.
+func searchSSDP(ifaceNames []string) ([]SSDPDevice, error) {
+	ifaces, err := mdnsInterfaces(ifaceNames)
+	if err != nil {
+		return nil, err
+	}
+	ifaceNamesByIndex := map[int]string{}
+	for _, ifi := range ifaces {
+		ifaceNamesByIndex[ifi.Index] = ifi.Name
+	}
+	// answers are unicast back to the port the search came from
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	p := ipv4.NewPacketConn(c)
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		log.Debug().Err(err).Msg("No interface control messages on SSDP socket")
+	}
+	// UDA 1.1 asks for a TTL of 2
+	if err := p.SetMulticastTTL(2); err != nil {
+		log.Debug().Err(err).Msg("Failed to set the SSDP multicast TTL")
+	}
+
+	var mu sync.Mutex
+	devices := map[string]*SSDPDevice{}
+	order := []string{}
+	deadline := time.Now().Add(ssdpSearchTime)
+	p.SetReadDeadline(deadline)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 9000)
+		for {
+			n, cm, src, err := p.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					log.Error().Err(err).Msg("Failed to read SSDP answers")
+				}
+				return
+			}
+			d, err := parseSSDPResponse(buf[:n])
+			if err != nil {
+				log.Debug().Err(err).Str("src", src.String()).Msg("Skipping bad SSDP answer")
+				continue
+			}
+			if udp, ok := src.(*net.UDPAddr); ok {
+				d.Source = udp.IP.String()
+			}
+			if cm != nil {
+				d.Interface = ifaceNamesByIndex[cm.IfIndex]
+			}
+			mu.Lock()
+			if _, ok := devices[d.Location]; !ok {
+				log.Debug().
+					Str("location", d.Location).
+					Str("server", d.Server).
+					Str("src", d.Source).
+					Str("interface", d.Interface).
+					Msg("SSDP answer")
+				devices[d.Location] = &d
+				order = append(order, d.Location)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// repeat the search until the deadline, answers can get lost on busy links
+	for time.Now().Before(deadline) {
+		for _, ifi := range ifaces {
+			if err := p.SetMulticastInterface(&ifi); err != nil {
+				log.Debug().Err(err).Str("interface", ifi.Name).Msg("Failed to send SSDP search")
+				continue
+			}
+			for _, st := range ssdpSearchTargets {
+				if _, err := p.WriteTo(buildMSearch(st), nil, ssdpGroupIPv4); err != nil {
+					log.Debug().Err(err).Str("interface", ifi.Name).Msg("Failed to send SSDP search")
+				}
+			}
+		}
+		time.Sleep(min(ssdpSearchInterval, time.Until(deadline)))
+	}
+	wg.Wait()
+
+	found := []SSDPDevice{}
+	for _, location := range order {
+		d := devices[location]
+		if err := d.describe(); err != nil {
+			log.Error().Err(err).Str("location", location).Msg("Error fetching UPnP description")
+		}
+		found = append(found, *d)
+	}
+	return found, nil
+}
+
+// describe fetches the LOCATION description and fills in the device fields.
+// Anyone on the link can answer an M-SEARCH so the description is only
+// fetched from the host that answered, never from a host it points us at.
+func (d *SSDPDevice) describe() error {
+	u, err := url.Parse(d.Location)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported LOCATION scheme %q", u.Scheme)
+	}
+	host, source := net.ParseIP(u.Hostname()), net.ParseIP(d.Source)
+	if host == nil || source == nil || !host.Equal(source) {
+		log.Debug().
+			Str("location", d.Location).
+			Str("src", d.Source).
+			Msg("LOCATION isn't on the answering host, not fetching the description")
+		return nil
+	}
+	resp, err := ssdpHTTPClient.Get(d.Location)
+	if err != nil {
+		return fmt.Errorf("error fetching URL: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+	}
+	var desc upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, ssdpMaxDescription)).Decode(&desc); err != nil {
+		return fmt.Errorf("error parsing description: %w", err)
+	}
+	dev := desc.Device
+	d.DeviceType = strings.TrimSpace(dev.DeviceType)
+	d.FriendlyName = strings.TrimSpace(dev.FriendlyName)
+	d.Manufacturer = strings.TrimSpace(dev.Manufacturer)
+	d.ManufacturerURL = strings.TrimSpace(dev.ManufacturerURL)
+	d.ModelName = strings.TrimSpace(dev.ModelName)
+	d.ModelNumber = strings.TrimSpace(dev.ModelNumber)
+	d.ModelDescription = strings.TrimSpace(dev.ModelDescription)
+	d.SerialNumber = strings.TrimSpace(dev.SerialNumber)
+	d.UDN = strings.TrimSpace(dev.UDN)
+	d.PresentationURL = strings.TrimSpace(dev.PresentationURL)
+	log.Debug().
+		Str("location", d.Location).
+		Str("friendly_name", d.FriendlyName).
+		Str("manufacturer", d.Manufacturer).
+		Str("model_name", d.ModelName).
+		Msg("UPnP device description")
+	return nil
+}
+
+// matchSSDPIndicator returns the first of the friendly name, manufacturer,
+// model name and serial number conditions that selects the device
+func matchSSDPIndicator(indicator SSDPIndicator, d SSDPDevice) (string, string, bool) {
+	fields := []struct {
+		field     string
+		indicator string
+		value     string
+	}{
+		{"friendly_name", indicator.FriendlyName, d.FriendlyName},
+		{"manufacturer", indicator.Manufacturer, d.Manufacturer},
+		{"model_name", indicator.ModelName, d.ModelName},
+		{"serial_number", indicator.SerialNumber, d.SerialNumber},
+	}
+	for _, f := range fields {
+		if f.indicator != "" && f.value != "" && strings.Contains(strings.ToLower(f.value), strings.ToLower(f.indicator)) {
+			return f.field, f.value, true
+		}
+	}
+	return "", "", false
+}
+
+// checkSSDP runs the ssdp indicators against every described device
+func checkSSDP(ssdp_indicators map[string][]SSDPIndicator, devices []SSDPDevice) []SSDPFinding {
+	findings := []SSDPFinding{}
+	vendors := slices.Sorted(maps.Keys(ssdp_indicators))
+	for _, d := range devices {
+		for _, vendor := range vendors {
+			for _, indicator := range ssdp_indicators[vendor] {
+				field, value, ok := matchSSDPIndicator(indicator, d)
+				if !ok {
+					continue
+				}
+				f := SSDPFinding{
+					Vendor:     vendor,
+					Confidence: indicator.Confidence,
+					Field:      field,
+					Value:      value,
+					Device:     d,
+					Reference:  indicator.Reference,
+				}
+				if f.Confidence == "" {
+					f.Confidence = "low"
+				}
+				log.Info().
+					Str("vendor", f.Vendor).
+					Str("field", f.Field).
+					Str("value", f.Value).
+					Str("location", d.Location).
+					Msg("UPnP device match found")
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings
+}
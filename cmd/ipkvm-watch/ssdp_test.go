@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const glkvmDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+    <friendlyName>GLKVM</friendlyName>
+    <manufacturer>GL Technologies (Hong Kong) Limited</manufacturer>
+    <modelName>RM1</modelName>
+    <serialNumber>94:83:c4:ae:ac:2a</serialNumber>
+    <UDN>uuid:2c9d1a6e-0000-0000-0000-9483c4aeac2a</UDN>
+  </device>
+</root>`
+
+// fakeSSDPResponder answers every M-SEARCH it gets with one response per
+// location, from 127.0.0.1
+func fakeSSDPResponder(t *testing.T, locations ...string) *net.UDPAddr {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, src, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1\r\n") {
+				continue
+			}
+			for _, location := range locations {
+				c.WriteToUDP(fmt.Appendf(nil, "HTTP/1.1 200 OK\r\n"+
+					"CACHE-CONTROL: max-age=1800\r\n"+
+					"LOCATION: %s\r\n"+
+					"SERVER: Linux/5.10 UPnP/1.0 GL.iNet/4.0\r\n"+
+					"ST: upnp:rootdevice\r\n"+
+					"USN: uuid:2c9d1a6e-0000-0000-0000-9483c4aeac2a::upnp:rootdevice\r\n\r\n", location), src)
+			}
+		}
+	}()
+	return c.LocalAddr().(*net.UDPAddr)
+}
+
+func TestSearchSSDP(t *testing.T) {
+	if _, err := mdnsInterfaces(nil); err != nil {
+		t.Skip(err)
+	}
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(glkvmDescription))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	defer func(group *net.UDPAddr, client *http.Client, searchTime time.Duration, interval time.Duration) {
+		ssdpGroupIPv4, ssdpHTTPClient, ssdpSearchTime, ssdpSearchInterval = group, client, searchTime, interval
+	}(ssdpGroupIPv4, ssdpHTTPClient, ssdpSearchTime, ssdpSearchInterval)
+	// the second answer points somewhere other than the answering host, it
+	// resolves to the same server but mustn't be fetched
+	ssdpGroupIPv4 = fakeSSDPResponder(t,
+		"http://127.0.0.1:"+port+"/rootDesc.xml",
+		"http://localhost:"+port+"/rootDesc.xml")
+	ssdpHTTPClient = srv.Client()
+	ssdpSearchTime, ssdpSearchInterval = 500*time.Millisecond, 100*time.Millisecond
+
+	devices, err := searchSSDP(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2: %+v", len(devices), devices)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("got %d description fetches, want 1", n)
+	}
+	for _, d := range devices {
+		if d.Source != "127.0.0.1" || d.Server != "Linux/5.10 UPnP/1.0 GL.iNet/4.0" {
+			t.Errorf("got source %q server %q", d.Source, d.Server)
+		}
+		described := d.FriendlyName != ""
+		if described != strings.Contains(d.Location, "127.0.0.1") {
+			t.Errorf("%s: described %v", d.Location, described)
+		}
+	}
+
+	indicators := map[string][]SSDPIndicator{"Comet": {{Manufacturer: "GL Technologies", Confidence: "medium"}}}
+	findings := checkSSDP(indicators, devices)
+	if len(findings) != 1 || findings[0].Field != "manufacturer" || findings[0].Device.ModelName != "RM1" {
+		t.Errorf("got findings %+v", findings)
+	}
+}
+
+func TestParseSSDPResponseNoLocation(t *testing.T) {
+	if _, err := parseSSDPResponse([]byte("HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\n\r\n")); err == nil {
+		t.Error("expected an error for an answer without a LOCATION")
+	}
+}
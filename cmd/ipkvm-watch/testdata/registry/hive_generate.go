@@ -0,0 +1,284 @@
+//go:build ignore
+
+// hive_generate.go writes SYSTEM, a small regf hive with the Enum\USB and
+// Enum\USBSTOR keys windows 10 leaves behind for a Comet, for
+// usb_registry_test.go. Layout per
+// https://github.com/msuhanov/regf/blob/master/Windows%20registry%20file%20format%20specification.md
+//
+//	go run hive_generate.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	regSZ       = 1
+	regDWORD    = 4
+	regMultiSZ  = 7
+	devpropStr  = 0xffff0012
+	devpropList = 0xffff2012
+	devpropTime = 0xffff0010
+)
+
+type value struct {
+	name string
+	typ  uint32
+	data []byte
+}
+
+type key struct {
+	name      string
+	lastWrite time.Time
+	values    []value
+	subkeys   []*key
+}
+
+func (k *key) sub(name string, lastWrite time.Time, values ...value) *key {
+	s := &key{name: name, lastWrite: lastWrite, values: values}
+	k.subkeys = append(k.subkeys, s)
+	return s
+}
+
+func (k *key) path(lastWrite time.Time, path string) *key {
+	for _, part := range strings.Split(path, `\`) {
+		i := slices.IndexFunc(k.subkeys, func(s *key) bool { return strings.EqualFold(s.name, part) })
+		if i < 0 {
+			k = k.sub(part, lastWrite)
+			continue
+		}
+		k = k.subkeys[i]
+	}
+	return k
+}
+
+func utf16z(strs ...string) []byte {
+	b := []byte{}
+	for _, s := range strs {
+		for _, u := range utf16.Encode([]rune(s + "\x00")) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+	}
+	return b
+}
+
+func sz(name string, s string) value { return value{name, regSZ, utf16z(s)} }
+
+func multiSZ(name string, strs ...string) value {
+	return value{name, regMultiSZ, append(utf16z(strs...), 0, 0)}
+}
+
+func dword(name string, n uint32) value {
+	return value{name, regDWORD, binary.LittleEndian.AppendUint32(nil, n)}
+}
+
+func filetime(t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixNano()/100+116444736000000000))
+}
+
+// property is a Properties\{guid}\nnnn key holding its default value
+func property(instance *key, lastWrite time.Time, guid string, id string, typ uint32, data []byte) {
+	instance.path(lastWrite, `Properties\`+guid).sub(id, lastWrite, value{"", typ, data})
+}
+
+// hive lays cells out one after another in a single hbin
+type hive struct {
+	bins []byte
+	sk   uint32
+}
+
+// alloc appends an allocated cell and returns its offset
+func (h *hive) alloc(data []byte) uint32 {
+	offset := uint32(len(h.bins))
+	size := (len(data) + 4 + 7) &^ 7
+	h.bins = binary.LittleEndian.AppendUint32(h.bins, uint32(-int32(size)))
+	h.bins = append(h.bins, data...)
+	h.bins = append(h.bins, make([]byte, size-4-len(data))...)
+	return offset
+}
+
+func (h *hive) put(offset uint32, at int, v uint32) {
+	binary.LittleEndian.PutUint32(h.bins[int(offset)+4+at:], v)
+}
+
+// lhHash is the name hint of an lh list entry
+func lhHash(name string) uint32 {
+	var hash uint32
+	for _, r := range strings.ToUpper(name) {
+		hash = hash*37 + uint32(r)
+	}
+	return hash
+}
+
+// writeKey writes the key, its values and its subkeys and returns the
+// offset of its nk cell
+func (h *hive) writeKey(k *key, parent uint32, root bool) uint32 {
+	nk := make([]byte, 76, 76+len(k.name))
+	copy(nk, "nk")
+	flags := uint16(0x0020) // compressed name
+	if root {
+		flags |= 0x0004 | 0x0008 // hive entry, no delete
+	}
+	binary.LittleEndian.PutUint16(nk[2:], flags)
+	copy(nk[4:], filetime(k.lastWrite))
+	binary.LittleEndian.PutUint32(nk[16:], parent)
+	binary.LittleEndian.PutUint32(nk[20:], uint32(len(k.subkeys)))
+	binary.LittleEndian.PutUint32(nk[28:], 0xffffffff)
+	binary.LittleEndian.PutUint32(nk[32:], 0xffffffff)
+	binary.LittleEndian.PutUint32(nk[36:], uint32(len(k.values)))
+	binary.LittleEndian.PutUint32(nk[40:], 0xffffffff)
+	binary.LittleEndian.PutUint32(nk[44:], h.sk)
+	binary.LittleEndian.PutUint32(nk[48:], 0xffffffff)
+	binary.LittleEndian.PutUint16(nk[72:], uint16(len(k.name)))
+	nk = append(nk, k.name...)
+	for _, s := range k.subkeys {
+		nk[52] = max(nk[52], byte(len(s.name)*2))
+	}
+	offset := h.alloc(nk)
+
+	if len(k.values) > 0 {
+		list := []byte{}
+		for _, v := range k.values {
+			list = binary.LittleEndian.AppendUint32(list, h.writeValue(v))
+		}
+		h.put(offset, 40, h.alloc(list))
+	}
+	if len(k.subkeys) > 0 {
+		// lh lists are sorted by the uppercase name
+		subkeys := slices.Clone(k.subkeys)
+		slices.SortFunc(subkeys, func(a, b *key) int {
+			return strings.Compare(strings.ToUpper(a.name), strings.ToUpper(b.name))
+		})
+		lh := []byte("lh")
+		lh = binary.LittleEndian.AppendUint16(lh, uint16(len(subkeys)))
+		for _, s := range subkeys {
+			lh = binary.LittleEndian.AppendUint32(lh, h.writeKey(s, offset, false))
+			lh = binary.LittleEndian.AppendUint32(lh, lhHash(s.name))
+		}
+		h.put(offset, 28, h.alloc(lh))
+	}
+	return offset
+}
+
+func (h *hive) writeValue(v value) uint32 {
+	vk := make([]byte, 20, 20+len(v.name))
+	copy(vk, "vk")
+	binary.LittleEndian.PutUint16(vk[2:], uint16(len(v.name)))
+	binary.LittleEndian.PutUint32(vk[12:], v.typ)
+	if v.name != "" {
+		binary.LittleEndian.PutUint16(vk[16:], 0x0001) // compressed name
+	}
+	vk = append(vk, v.name...)
+	if len(v.data) <= 4 {
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(v.data))|0x80000000)
+		copy(vk[8:12], v.data)
+		return h.alloc(vk)
+	}
+	binary.LittleEndian.PutUint32(vk[4:], uint32(len(v.data)))
+	binary.LittleEndian.PutUint32(vk[8:], h.alloc(v.data))
+	return h.alloc(vk)
+}
+
+// writeSecurity writes the one sk cell every key shares, with an empty
+// self relative descriptor
+func (h *hive) writeSecurity() {
+	sk := []byte("sk\x00\x00")
+	h.sk = uint32(len(h.bins))
+	sk = binary.LittleEndian.AppendUint32(sk, h.sk) // flink
+	sk = binary.LittleEndian.AppendUint32(sk, h.sk) // blink
+	sk = binary.LittleEndian.AppendUint32(sk, 0)    // reference count, patched below
+	descriptor := []byte{1, 0, 0x00, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	sk = binary.LittleEndian.AppendUint32(sk, uint32(len(descriptor)))
+	h.alloc(append(sk, descriptor...))
+}
+
+func countKeys(k *key) uint32 {
+	n := uint32(1)
+	for _, s := range k.subkeys {
+		n += countKeys(s)
+	}
+	return n
+}
+
+func main() {
+	installed := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	removed := time.Date(2025, 10, 2, 18, 30, 0, 0, time.UTC)
+	arrived := time.Date(2025, 10, 3, 9, 14, 58, 0, time.UTC)
+	written := time.Date(2025, 10, 3, 9, 15, 0, 0, time.UTC)
+
+	root := &key{name: "ROOT", lastWrite: written}
+	root.sub("Select", installed, dword("Current", 1), dword("Default", 1), dword("LastKnownGood", 1))
+	enum := root.path(installed, `ControlSet001\Enum`)
+
+	parent := enum.path(installed, `USB\VID_1D6B&PID_0104`).sub("CAFEBABE", written,
+		sz("DeviceDesc", "@usb.inf,%usb\\compositeparent.devicedesc%;USB Composite Device"),
+		sz("Mfg", "@usb.inf,%generic.mfg%;(Standard USB Host Controller)"),
+		sz("Service", "usbccgp"),
+		multiSZ("CompatibleIDs", `USB\DevClass_00&SubClass_00&Prot_00`, `USB\DevClass_00&SubClass_00`, `USB\DevClass_00`, `USB\COMPOSITE`),
+		sz("ParentIdPrefix", "7&1a2b3c4&0"))
+	// DEVPKEY_Device_BusReportedDeviceDesc and DEVPKEY_Device_CompatibleIds
+	property(parent, written, "{540b947e-8b40-45bc-a8a2-6a0b894cbda2}", "0004", devpropStr, utf16z("Comet"))
+	property(parent, written, "{a45c254e-df1c-4efd-8020-67d146a850e0}", "0004", devpropList,
+		append(utf16z(`USB\DevClass_00&SubClass_00&Prot_00`, `USB\COMPOSITE`), 0, 0))
+	for _, p := range []struct {
+		id string
+		t  time.Time
+	}{{"0064", installed}, {"0065", installed}, {"0066", arrived}, {"0067", removed}} {
+		property(parent, written, "{83da6326-97a6-4088-9453-a1923f573b29}", p.id, devpropTime, filetime(p.t))
+	}
+
+	hid := enum.path(installed, `USB\VID_1D6B&PID_0104&MI_00`).sub("7&1a2b3c4&0&0000", arrived,
+		sz("DeviceDesc", "@input.inf,%hid.devicedesc%;USB Input Device"),
+		sz("Service", "HidUsb"),
+		multiSZ("CompatibleIDs", `USB\Class_03&SubClass_01&Prot_01`, `USB\Class_03&SubClass_01`, `USB\Class_03`))
+	property(hid, arrived, "{540b947e-8b40-45bc-a8a2-6a0b894cbda2}", "0004", devpropStr, utf16z("Comet"))
+
+	enum.path(installed, `USBSTOR\CdRom&Ven_GLINET&Prod_FLASH_DRIVE&Rev_1.00`).sub("CAFEBABE&0", arrived,
+		sz("FriendlyName", "GLINET FLASH DRIVE USB Device"),
+		sz("DeviceDesc", "@cdrom.inf,%gencdrom_devdesc%;CD-ROM Drive"),
+		sz("Service", "cdrom"))
+
+	h := &hive{bins: []byte{}}
+	h.bins = append(h.bins, "hbin"...)
+	h.bins = append(h.bins, make([]byte, 28)...)
+	h.writeSecurity()
+	rootOffset := h.writeKey(root, 0xffffffff, true)
+	h.put(h.sk, 12, countKeys(root))
+
+	// the hbin is padded with one free cell to a multiple of 4096
+	size := (len(h.bins) + 8 + 4095) &^ 4095
+	free := size - len(h.bins)
+	h.bins = binary.LittleEndian.AppendUint32(h.bins, uint32(free))
+	h.bins = append(h.bins, make([]byte, free-4)...)
+	binary.LittleEndian.PutUint32(h.bins[8:], uint32(size))
+	copy(h.bins[20:], filetime(written))
+
+	base := make([]byte, 4096)
+	copy(base, "regf")
+	binary.LittleEndian.PutUint32(base[4:], 1)
+	binary.LittleEndian.PutUint32(base[8:], 1)
+	copy(base[12:], filetime(written))
+	binary.LittleEndian.PutUint32(base[20:], 1) // major
+	binary.LittleEndian.PutUint32(base[24:], 5) // minor
+	binary.LittleEndian.PutUint32(base[32:], 1) // direct memory load
+	binary.LittleEndian.PutUint32(base[36:], rootOffset)
+	binary.LittleEndian.PutUint32(base[40:], uint32(size))
+	binary.LittleEndian.PutUint32(base[44:], 1)
+	copy(base[48:112], utf16z(`\SystemRoot\System32\Config\SYSTEM`))
+	var checksum uint32
+	for i := 0; i < 508; i += 4 {
+		checksum ^= binary.LittleEndian.Uint32(base[i:])
+	}
+	binary.LittleEndian.PutUint32(base[508:], checksum)
+
+	if err := os.WriteFile("SYSTEM", bytes.Join([][]byte{base, h.bins}, nil), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Uevent is a kernel uevent message
+// ex: "add@/devices/.../1-1\x00ACTION=add\x00DEVPATH=/devices/.../1-1\x00SUBSYSTEM=usb\x00..."
+type Uevent struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	DevType   string
+	Env       map[string]string
+}
+
+// ueventSettleTime is how long to wait after an add before reading sysfs,
+// the interfaces are created after the device itself
+var ueventSettleTime = time.Second
+
+// parseUevent parses a single NETLINK_KOBJECT_UEVENT message from the kernel
+func parseUevent(msg []byte) (Uevent, error) {
+	ev := Uevent{Env: map[string]string{}}
+	// udevd rebroadcasts with its own binary header, we only want the kernel's
+	if bytes.HasPrefix(msg, []byte("libudev\x00")) {
+		return ev, fmt.Errorf("udev message, not a kernel uevent")
+	}
+	parts := bytes.Split(msg, []byte{0})
+	if len(parts) == 0 || !bytes.Contains(parts[0], []byte("@")) {
+		return ev, fmt.Errorf("missing uevent header")
+	}
+	for _, part := range parts[1:] {
+		k, v, ok := strings.Cut(string(part), "=")
+		if !ok {
+			continue
+		}
+		ev.Env[k] = v
+	}
+	ev.Action = ev.Env["ACTION"]
+	ev.DevPath = ev.Env["DEVPATH"]
+	ev.Subsystem = ev.Env["SUBSYSTEM"]
+	ev.DevType = ev.Env["DEVTYPE"]
+	if ev.Action == "" || ev.DevPath == "" {
+		return ev, fmt.Errorf("uevent without ACTION or DEVPATH")
+	}
+	return ev, nil
+}
+
+// isUSBDeviceAdd reports if the event is a usb device (not interface)
+// being plugged in, the only events the watch mode acts on
+func (ev Uevent) isUSBDeviceAdd() bool {
+	return ev.Action == "add" && ev.Subsystem == "usb" && ev.DevType == "usb_device"
+}
+
+// ueventUSBDevice builds a record for the device in an add event. sysfs is
+// used when the device is still there, otherwise the PRODUCT variable
+// (vid/pid/bcdDevice in hex without padding, ex: 1d6b/104/100) is used.
+func ueventUSBDevice(ev Uevent, sysRoot string) USBDeviceRecord {
+	dev, err := readSysfsUSBDevice(filepath.Join(sysRoot, ev.DevPath))
+	if err == nil {
+		return dev
+	}
+	log.Debug().Err(err).Str("devpath", ev.DevPath).Msg("Device not readable in sysfs, using uevent variables")
+	dev = USBDeviceRecord{
+		Bus:     formatSysfsNumber(ev.Env["BUSNUM"]),
+		Address: formatSysfsNumber(ev.Env["DEVNUM"]),
+	}
+	product := strings.Split(ev.Env["PRODUCT"], "/")
+	if len(product) == 3 {
+		vid, _ := strconv.ParseUint(product[0], 16, 16)
+		pid, _ := strconv.ParseUint(product[1], 16, 16)
+		bcd, _ := strconv.ParseUint(product[2], 16, 16)
+		dev.VendorID = fmt.Sprintf("%04x", vid)
+		dev.ProductID = fmt.Sprintf("%04x", pid)
+		dev.BCDDevice = formatSysfsBCD(fmt.Sprintf("%04x", bcd))
+	}
+	return dev
+}
+
+// handleUevent runs the usb indicators and heuristics for a usb device add
+// event and prints each finding as a json line. It's split from the socket
+// so captured messages can be replayed through it.
+func handleUevent(ev Uevent, usbIndicators map[string][]USBDevice, sysRoot string) []USBFinding {
+	if !ev.isUSBDeviceAdd() {
+		return nil
+	}
+	log.Info().Str("devpath", ev.DevPath).Str("product", ev.Env["PRODUCT"]).Msg("USB device added")
+	dev := ueventUSBDevice(ev, sysRoot)
+	devices := []USBDeviceRecord{dev}
+	findings := matchUSBDevices(usbIndicators, devices)
+	findings = append(findings, checkUSBHeuristics(devices)...)
+	for _, f := range findings {
+		b, err := json.Marshal(f)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to marshal finding as json")
+			continue
+		}
+		fmt.Println(string(b))
+	}
+	return findings
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// readUevents turns the captured messages back into what the socket
+// returns, the fixture has one variable per line and a blank line between
+// messages instead of NUL separators
+func readUevents(t *testing.T, path string) [][]byte {
+	t.Helper()
+	msgs := [][]byte{}
+	for _, block := range strings.Split(strings.TrimSpace(readFixture(t, path)), "\n\n") {
+		msgs = append(msgs, []byte(strings.ReplaceAll(block, "\n", "\x00")+"\x00"))
+	}
+	return msgs
+}
+
+func TestReplayUevents(t *testing.T) {
+	indicators := map[string][]USBDevice{"Generic": {{VID: "1d6b", PID: "0104"}}}
+	sysRoot := t.TempDir()
+
+	adds := []USBDeviceRecord{}
+	findings := []USBFinding{}
+	for _, msg := range readUevents(t, "testdata/uevents") {
+		ev, err := parseUevent(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.isUSBDeviceAdd() {
+			adds = append(adds, ueventUSBDevice(ev, sysRoot))
+		}
+		findings = append(findings, handleUevent(ev, indicators, sysRoot)...)
+	}
+	if len(adds) != 2 {
+		t.Fatalf("got %d device adds, want 2", len(adds))
+	}
+	gadget := adds[0]
+	if gadget.VendorID != "1d6b" || gadget.ProductID != "0104" || gadget.BCDDevice != "1.00" ||
+		gadget.Bus != "001" || gadget.Address != "004" {
+		t.Errorf("got gadget %+v", gadget)
+	}
+	if adds[1].VendorID != "046d" || adds[1].ProductID != "c31c" || adds[1].BCDDevice != "64.00" {
+		t.Errorf("got keyboard %+v", adds[1])
+	}
+
+	// the indicator and the Linux Foundation gadget heuristic, nothing for
+	// the interface, bind and remove events or the keyboard
+	vendors := []string{}
+	for _, f := range findings {
+		if f.VID != "1d6b" || f.Address != "004" {
+			t.Errorf("unexpected finding %+v", f)
+		}
+		vendors = append(vendors, f.Vendor)
+	}
+	if strings.Join(vendors, ",") != "Generic,unknown" {
+		t.Errorf("got findings from %v, want Generic and unknown", vendors)
+	}
+}
+
+func TestParseUeventRejects(t *testing.T) {
+	for name, msg := range map[string]string{
+		"udev":      "libudev\x00\xfe\xed\xca\xfeACTION=add\x00",
+		"no header": "ACTION=add\x00DEVPATH=/devices/x\x00",
+		"no action": "add@/devices/x\x00DEVPATH=/devices/x\x00",
+	} {
+		if _, err := parseUevent([]byte(msg)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"os/exec"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -20,160 +20,326 @@ func getMacOsUSBDevices() string {
 	return string(out)
 }
 func getLinuxUSBDevices() string {
-	// run ['ioreg', '-p', 'IOUSB', '-l', '-w', '0'] and return output as string
+	// run ['lsusb', '-v'] and return output as string
 	out, err := exec.Command("lsusb", "-v").Output()
 	if err != nil {
 		log.Error().Err(err).Msg("subprocess to get USB devices failed")
 	}
 	return string(out)
 }
+
+// getLinuxUSBRecords reads devices from sysfs and only falls back to
+// lsusb when sysfs isn't available (ex: some containers)
+func getLinuxUSBRecords() []USBDeviceRecord {
+	devices, err := readSysfsUSBDevices(sysfsUSBRoot)
+	if err == nil && len(devices) > 0 {
+		log.Debug().Int("count", len(devices)).Msg("Read USB devices from sysfs")
+		return devices
+	}
+	log.Debug().Err(err).Msg("sysfs USB enumeration unavailable, falling back to lsusb")
+	devices = parseLsusb(getLinuxUSBDevices())
+	log.Debug().Int("count", len(devices)).Msg("Parsed USB devices from lsusb")
+	return devices
+}
 func getWindowsUSBDevices() string {
-	out, err := exec.Command("powershell.exe", "-c", "pnputil", "/enum-devices", "/connected", "/class USB").Output()
+	out, err := exec.Command("powershell.exe", "-c", "pnputil", "/enum-devices", "/connected", "/relations", "/class USB").Output()
 	if err != nil {
 		log.Error().Err(err).Msg("subprocess to get USB devices failed")
 	}
-	return string(out)
+	// pnputil only lists the USB class, the HID and USBSTOR children (and
+	// their compatible ids) come from Win32_PnPEntity. Their parent isn't a
+	// Win32_PnPEntity property so it's added from DEVPKEY_Device_Parent.
+	cim, err := exec.Command("powershell.exe", "-c",
+		`Get-CimInstance -ClassName Win32_PnPEntity | Where-Object { $_.PNPDeviceID -match '^(USB|USBSTOR|HID)\\' } | `+
+			`ForEach-Object { $_ | Add-Member -NotePropertyName Parent -NotePropertyValue (Get-PnpDeviceProperty -InstanceId $_.PNPDeviceID -KeyName DEVPKEY_Device_Parent).Data -PassThru } | Format-List *`).Output()
+	if err != nil {
+		log.Error().Err(err).Msg("subprocess to get PnP entities failed")
+	}
+	return string(out) + "\n" + string(cim)
 }
 
 type USBFinding struct {
-	Vendor       string
-	Manufacturer string
-	Confidence   string
+	Vendor        string
+	Manufacturer  string
+	Confidence    string
+	Bus           string
+	Address       string
+	VID           string
+	PID           string
+	MatchedFields []string
+	Reference     string
+	// set for findings from the heuristic checks instead of an indicator
+	Heuristic bool
+	Score     int
+	Reasons   []string
+	// set for devices that were seen in the past (logs, registry) but
+	// aren't necessarily plugged in now
+	Historical bool
+	Source     string
+	FirstSeen  string
+	LastSeen   string
 }
 
-func checkUSBDevices(usbIndicators map[string][]USBDevice) []USBFinding {
-	findings := []USBFinding{}
-	// get the usb devices
-	var usboutput string
-	if runtime.GOOS == "darwin" {
-		usboutput = getMacOsUSBDevices()
-		usboutput = strings.ToLower(usboutput)
-	} else if runtime.GOOS == "linux" {
-		usboutput = getLinuxUSBDevices()
-		usboutput = strings.ToLower(usboutput)
-	} else if runtime.GOOS == "windows" {
-		usboutput = getWindowsUSBDevices()
-		usboutput = strings.ToLower(usboutput)
-	} else {
-		log.Warn().Str("os", runtime.GOOS).Msg("USB discovery not supported on this OS")
-		return findings
+// USBDeviceRecord is a single USB device as reported by the OS
+type USBDeviceRecord struct {
+	Bus            string
+	Address        string
+	VendorID       string // lowercase hex, ex: 1d6b
+	ProductID      string // lowercase hex, ex: 0104
+	VendorName     string
+	ProductName    string
+	Class          int
+	BCDDevice      string
+	Manufacturer   string
+	Product        string
+	Serial         string
+	Location       string   // macOS locationID
+	Signature      string   // macOS UsbDeviceSignature as hex
+	InstanceID     string   // windows device instance id
+	Instances      []string // windows instance ids of the device and its children
+	Configurations []USBConfiguration
+}
+
+// USBConfiguration is one configuration descriptor of a device
+type USBConfiguration struct {
+	Value      int
+	Name       string
+	Interfaces []USBInterface
+}
+
+// USBInterface is one interface descriptor of a configuration
+type USBInterface struct {
+	Number           int
+	AlternateSetting int
+	Class            int
+	SubClass         int
+	Protocol         int
+	Name             string
+	Endpoints        []USBEndpoint
+}
+
+// USBEndpoint is one endpoint descriptor of an interface
+type USBEndpoint struct {
+	Address       string
+	Direction     string
+	TransferType  string
+	MaxPacketSize int
+}
+
+// searchText is every descriptive string of the device lowercased, it is
+// what windows_search_string is looked for in
+func (dev USBDeviceRecord) searchText() string {
+	text := []string{dev.Manufacturer, dev.Product, dev.VendorName, dev.ProductName, dev.InstanceID}
+	for _, config := range dev.Configurations {
+		for _, iface := range config.Interfaces {
+			text = append(text, iface.Name)
+		}
 	}
+	return strings.ToLower(strings.Join(text, "\n"))
+}
 
-	for vendor, devices := range usbIndicators {
-		for _, device := range devices {
-			// first check if the search string (manufacturer) is in the output
-			if strings.Contains(usboutput, strings.ToLower(device.Manufacturer)) {
-				f := USBFinding{
-					Vendor:       vendor,
-					Manufacturer: device.Manufacturer,
-					Confidence:   "high",
-				}
-				findings = append(findings, f)
-				log.Info().
-					Str("vendor", vendor).
-					Str("manufacturer", device.Manufacturer).
-					Str("confidence", "high").
-					Msg("Matched USB device manufacturer")
+// confidenceRank orders the confidence strings used in indicators and findings
+func confidenceRank(confidence string) int {
+	switch strings.ToLower(confidence) {
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+// matchUSBDevice checks a single device against a single indicator. Every
+// field has to come from the same device so strings from two different
+// devices can't combine into a match. The finding uses the confidence the
+// rule declares, rules without one get a confidence from the fields that
+// matched (manufacturer/product high, serial medium, vid/pid medium for
+// windows instance ids and low otherwise).
+func matchUSBDevice(vendor string, indicator USBDevice, dev USBDeviceRecord) (USBFinding, bool) {
+	f := USBFinding{
+		Vendor:        vendor,
+		Manufacturer:  indicator.Manufacturer,
+		Bus:           dev.Bus,
+		Address:       dev.Address,
+		VID:           dev.VendorID,
+		PID:           dev.ProductID,
+		MatchedFields: []string{},
+		Reference:     indicator.Reference,
+	}
+	// constraints have to hold before any field is considered
+	if !matchInterfaceClasses(indicator.InterfaceClasses, dev) || !matchBCDRange(indicator, dev) {
+		return f, false
+	}
+	matched := func(confidence string, fields ...string) {
+		f.MatchedFields = append(f.MatchedFields, fields...)
+		if confidenceRank(f.Confidence) < confidenceRank(confidence) {
+			f.Confidence = confidence
+		}
+	}
+	if indicator.Manufacturer != "" && strings.Contains(strings.ToLower(dev.Manufacturer), strings.ToLower(indicator.Manufacturer)) {
+		matched("high", "manufacturer")
+	}
+	if indicator.Product != "" && strings.Contains(strings.ToLower(dev.Product), strings.ToLower(indicator.Product)) {
+		matched("high", "product")
+	}
+	if indicator.Serial != "" && strings.EqualFold(dev.Serial, indicator.Serial) {
+		matched("medium", "serial")
+	}
+	if indicator.SerialRegex != "" && dev.Serial != "" {
+		re, err := regexp.Compile(indicator.SerialRegex)
+		if err != nil {
+			log.Error().Err(err).Str("vendor", vendor).Str("serial_regex", indicator.SerialRegex).Msg("Invalid USB serial regex")
+		} else if re.MatchString(dev.Serial) {
+			matched("medium", "serial_regex")
+		}
+	}
+	if indicator.WindowsSearchString != "" && strings.Contains(dev.searchText(), strings.ToLower(indicator.WindowsSearchString)) {
+		matched("medium", "windows_search_string")
+	}
+	if indicator.VID != "" && indicator.PID != "" &&
+		strings.EqualFold(dev.VendorID, indicator.VID) && strings.EqualFold(dev.ProductID, indicator.PID) {
+		// windows ids have always been reported at medium, keep that for
+		// pnputil, registry and setupapi devices
+		if dev.InstanceID != "" {
+			matched("medium", "vid", "pid")
+		} else {
+			matched("low", "vid", "pid")
+		}
+	}
+	if len(f.MatchedFields) == 0 {
+		return f, false
+	}
+	if len(indicator.InterfaceClasses) > 0 {
+		f.MatchedFields = append(f.MatchedFields, "interface_classes")
+	}
+	if indicator.BCDDeviceMin != "" || indicator.BCDDeviceMax != "" {
+		f.MatchedFields = append(f.MatchedFields, "bcd_device")
+	}
+	if indicator.Confidence != "" {
+		f.Confidence = indicator.Confidence
+	}
+	return f, true
+}
+
+// matchInterfaceClasses checks that every class signature in the rule is
+// present on one of the device's interfaces. Signatures are hex and can
+// leave off the subclass and protocol, ex: '08', '03:01' or '03:01:01'.
+func matchInterfaceClasses(signatures []string, dev USBDeviceRecord) bool {
+	for _, signature := range signatures {
+		parts := strings.Split(signature, ":")
+		wanted := []int{}
+		for _, part := range parts {
+			v, err := strconv.ParseInt(strings.TrimSpace(part), 16, 32)
+			if err != nil {
+				log.Error().Err(err).Str("interface_class", signature).Msg("Invalid USB interface class signature")
+				return false
 			}
-			// next check for vid and pid
-			if runtime.GOOS == "darwin" {
-				// mac shows the vid and pid as ints and not hex so we need to convert
-				macvid, err := strconv.ParseInt(device.VID, 16, 64)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to parse VID to int for macOS USB discovery")
-					continue
-				}
-				macpid, err := strconv.ParseInt(device.PID, 16, 64)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to parse PID to int for macOS USB discovery")
-					continue
-				}
-				vidstr := strconv.FormatInt(macvid, 10)
-				pidstr := strconv.FormatInt(macpid, 10)
-				if strings.Contains(usboutput, "\"idVendor\" = "+vidstr) && strings.Contains(usboutput, "\"idProduct\" = "+pidstr) {
-					f := USBFinding{
-						Vendor:       vendor,
-						Manufacturer: device.Manufacturer,
-						Confidence:   "low",
-					}
-					findings = append(findings, f)
-					log.Info().
-						Str("vendor", vendor).
-						Str("manufacturer", device.Manufacturer).
-						Str("confidence", "low").
-						Msg("Matched USB device VID/PID")
-				}
-			} else if runtime.GOOS == "linux" {
-				// match the following regex: ".*<vid>.*\n.*<pid>.*\n"
-				r := fmt.Sprintf(".*%s.*\n.*%s.*\n", device.VID, device.PID)
-				re, err := regexp.Compile(r) // Matches one or more digits
-				if err != nil {
-					panic(err)
-				}
-				match := re.FindStringSubmatch(usboutput)
-				if len(match) > 0 {
-					f := USBFinding{
-						Vendor:       vendor,
-						Manufacturer: device.Manufacturer,
-						Confidence:   "low",
-					}
-					findings = append(findings, f)
-					log.Info().
-						Str("vendor", vendor).
-						Str("manufacturer", device.Manufacturer).
-						Str("confidence", "low").
-						Msg("Matched USB device VID/PID")
-				}
-			} else if runtime.GOOS == "windows" {
-				if device.WindowsSearchString != "" && strings.Contains(usboutput, device.WindowsSearchString) {
-					f := USBFinding{
-						Vendor:       vendor,
-						Manufacturer: device.Manufacturer,
-						Confidence:   "medium",
-					}
-					findings = append(findings, f)
-					log.Info().
-						Str("vendor", vendor).
-						Str("manufacturer", device.Manufacturer).
-						Str("confidence", "medium").
-						Msg("Matched USB Window device search string")
-				}
-				// also search on vid and pid
-				ss := fmt.Sprintf("VID_%s&PID_%s", device.VID, device.PID)
-				ss = strings.ToLower(ss)
-				if strings.Contains(usboutput, ss) {
-					f := USBFinding{
-						Vendor:       vendor,
-						Manufacturer: device.Manufacturer,
-						Confidence:   "medium",
-					}
-					findings = append(findings, f)
-					log.Info().
-						Str("vendor", vendor).
-						Str("manufacturer", device.Manufacturer).
-						Str("confidence", "medium").
-						Msg("Matched USB windows vid and pid")
+			wanted = append(wanted, int(v))
+		}
+		found := false
+		for _, config := range dev.Configurations {
+			for _, iface := range config.Interfaces {
+				have := []int{iface.Class, iface.SubClass, iface.Protocol}
+				if slices.Equal(have[:min(len(wanted), 3)], wanted[:min(len(wanted), 3)]) {
+					found = true
 				}
 			}
-			// check if the serial number is in the output
-			if device.Serial == "" {
-				continue
-			}
-			if strings.Contains(usboutput, strings.ToLower(device.Serial)) {
-				f := USBFinding{
-					Vendor:       vendor,
-					Manufacturer: device.Manufacturer,
-					Confidence:   "medium",
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchBCDRange checks the device's bcdDevice against the rule's range
+func matchBCDRange(indicator USBDevice, dev USBDeviceRecord) bool {
+	if indicator.BCDDeviceMin == "" && indicator.BCDDeviceMax == "" {
+		return true
+	}
+	bcd, ok := parseBCD(dev.BCDDevice)
+	if !ok {
+		return false
+	}
+	if low, ok := parseBCD(indicator.BCDDeviceMin); ok && bcd < low {
+		return false
+	}
+	if high, ok := parseBCD(indicator.BCDDeviceMax); ok && bcd > high {
+		return false
+	}
+	return true
+}
+
+// parseBCD reads a bcd version like "1.00" (lsusb) or "0x0100"/"0100" into
+// its 16 bit value
+func parseBCD(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if major, minor, ok := strings.Cut(value, "."); ok {
+		hi, err := strconv.ParseInt(major, 16, 32)
+		if err != nil {
+			return 0, false
+		}
+		lo, err := strconv.ParseInt(minor, 16, 32)
+		if err != nil {
+			return 0, false
+		}
+		return int(hi)<<8 | int(lo), true
+	}
+	v, err := strconv.ParseInt(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// matchUSBDevices runs every indicator against every device and returns
+// one finding per matching (device, indicator) pair
+func matchUSBDevices(usbIndicators map[string][]USBDevice, devices []USBDeviceRecord) []USBFinding {
+	findings := []USBFinding{}
+	for _, dev := range devices {
+		for vendor, indicators := range usbIndicators {
+			for _, indicator := range indicators {
+				f, ok := matchUSBDevice(vendor, indicator, dev)
+				if !ok {
+					continue
 				}
 				findings = append(findings, f)
 				log.Info().
 					Str("vendor", vendor).
-					Str("manufacturer", device.Manufacturer).
-					Str("confidence", "medium").
-					Msg("Matched USB device serial number")
+					Str("manufacturer", indicator.Manufacturer).
+					Str("confidence", f.Confidence).
+					Str("bus", dev.Bus).
+					Str("address", dev.Address).
+					Strs("matched", f.MatchedFields).
+					Msg("Matched USB device")
 			}
 		}
 	}
-
 	return findings
 }
+
+func checkUSBDevices(usbIndicators map[string][]USBDevice) []USBFinding {
+	findings := []USBFinding{}
+	// get the usb devices
+	var devices []USBDeviceRecord
+	if runtime.GOOS == "darwin" {
+		devices = ioregUSBDevices(parseIoreg(getMacOsUSBDevices()))
+		log.Debug().Int("count", len(devices)).Msg("Parsed USB devices from ioreg")
+	} else if runtime.GOOS == "linux" {
+		devices = getLinuxUSBRecords()
+	} else if runtime.GOOS == "windows" {
+		devices = parseWindowsUSB(getWindowsUSBDevices())
+		log.Debug().Int("count", len(devices)).Msg("Parsed USB devices from pnputil")
+	} else {
+		log.Warn().Str("os", runtime.GOOS).Msg("USB discovery not supported on this OS")
+		return findings
+	}
+
+	findings = matchUSBDevices(usbIndicators, devices)
+	return append(findings, checkUSBHeuristics(devices)...)
+}
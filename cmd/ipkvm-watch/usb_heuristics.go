@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// USB class codes used by the heuristics
+const (
+	usbClassCDC          = 0x02
+	usbClassHID          = 0x03
+	usbClassMassStorage  = 0x08
+	usbClassHub          = 0x09
+	usbClassCDCData      = 0x0a
+	usbClassWireless     = 0xe0
+	usbHIDProtoKeyboard  = 0x01
+	usbHIDProtoMouse     = 0x02
+	linuxFoundationVID   = "1d6b"
+	heuristicMinScore    = 4
+	heuristicMediumScore = 6
+	heuristicHighScore   = 8
+)
+
+// linuxRootHubPIDs are the 1d6b product ids used by the kernel's own root
+// hubs, every other 1d6b product is a gadget
+var linuxRootHubPIDs = []string{"0001", "0002", "0003"}
+
+// placeholderSerials are serial numbers that ship as defaults in gadget
+// scripts and KVM images
+var placeholderSerials = []string{
+	"cafebabe",
+	"deadbeef",
+	"0123456789",
+	"123456789",
+	"0123456789abcdef",
+	"fedcba9876543210",
+}
+
+// hidConfigName matches the generic configuration strings from gadget
+// scripts, ex: "Config 1: HID" or "Config 1: ECM network"
+var hidConfigName = regexp.MustCompile(`(?i)^config \d+: (hid|ecm|rndis|mass storage)`)
+
+// scoreUSBDevice scores a device on how much it looks like the USB side of
+// an IP KVM. KVMs show up as a single composite gadget that acts as a
+// keyboard, a mouse, and often virtual media and a network adapter, which
+// is a rare combination for real peripherals.
+func scoreUSBDevice(dev USBDeviceRecord) (int, []string) {
+	score := 0
+	reasons := []string{}
+	if dev.Class == usbClassHub {
+		return score, reasons
+	}
+
+	hasKeyboard, hasPointer, hasStorage, hasNetwork := false, false, false, false
+	for _, config := range dev.Configurations {
+		for _, iface := range config.Interfaces {
+			switch iface.Class {
+			case usbClassHID:
+				// only boot protocol interfaces count, report protocol ones
+				// are as often consumer control (media keys) or vendor
+				// defined as they are pointers. Absolute pointers are
+				// picked up from the report descriptor by the input checks.
+				switch iface.Protocol {
+				case usbHIDProtoKeyboard:
+					hasKeyboard = true
+				case usbHIDProtoMouse:
+					hasPointer = true
+				}
+			case usbClassMassStorage:
+				hasStorage = true
+			case usbClassCDC, usbClassCDCData, usbClassWireless:
+				// CDC-ECM/NCM and RNDIS (wireless class on linux gadgets)
+				hasNetwork = true
+			}
+		}
+	}
+	if hasKeyboard {
+		score++
+		reasons = append(reasons, "HID keyboard interface")
+	}
+	if hasPointer {
+		score++
+		reasons = append(reasons, "HID mouse interface")
+	}
+	if hasKeyboard && hasPointer && hasStorage {
+		score += 2
+		reasons = append(reasons, "keyboard, pointer and mass storage on one composite device")
+	}
+	if hasNetwork && (hasKeyboard || hasPointer) {
+		score++
+		reasons = append(reasons, "HID and network interfaces on one composite device")
+	}
+
+	if strings.EqualFold(dev.VendorID, linuxFoundationVID) && !containsFold(linuxRootHubPIDs, dev.ProductID) {
+		score += 3
+		reasons = append(reasons, fmt.Sprintf("Linux Foundation gadget id %s:%s", dev.VendorID, dev.ProductID))
+	}
+
+	for _, config := range dev.Configurations {
+		if hidConfigName.MatchString(config.Name) || strings.Contains(strings.ToLower(config.Name), "kvm") {
+			score++
+			reasons = append(reasons, fmt.Sprintf("gadget configuration name %q", config.Name))
+			break
+		}
+	}
+
+	if dev.Serial == "" {
+		score++
+		reasons = append(reasons, "empty serial number")
+	} else if isPlaceholderSerial(dev.Serial) {
+		score += 2
+		reasons = append(reasons, fmt.Sprintf("placeholder serial number %q", dev.Serial))
+	}
+	return score, reasons
+}
+
+// isPlaceholderSerial reports if the serial is a well known default or a
+// single repeated character (ex: 000000)
+func isPlaceholderSerial(serial string) bool {
+	if containsFold(placeholderSerials, serial) {
+		return true
+	}
+	return len(serial) > 1 && strings.Count(serial, serial[:1]) == len(serial)
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// heuristicConfidence maps a score to the confidence strings used in indicators
+func heuristicConfidence(score int) string {
+	if score >= heuristicHighScore {
+		return "high"
+	} else if score >= heuristicMediumScore {
+		return "medium"
+	}
+	return "low"
+}
+
+// checkUSBHeuristics scores every device and returns findings for the ones
+// that look like KVMs regardless of what their strings say
+func checkUSBHeuristics(devices []USBDeviceRecord) []USBFinding {
+	findings := []USBFinding{}
+	for _, dev := range devices {
+		score, reasons := scoreUSBDevice(dev)
+		if score < heuristicMinScore {
+			continue
+		}
+		f := USBFinding{
+			Vendor:        "unknown",
+			Manufacturer:  dev.Manufacturer,
+			Confidence:    heuristicConfidence(score),
+			Bus:           dev.Bus,
+			Address:       dev.Address,
+			VID:           dev.VendorID,
+			PID:           dev.ProductID,
+			MatchedFields: []string{},
+			Heuristic:     true,
+			Score:         score,
+			Reasons:       reasons,
+		}
+		findings = append(findings, f)
+		log.Info().
+			Str("manufacturer", dev.Manufacturer).
+			Str("confidence", f.Confidence).
+			Int("score", score).
+			Strs("reasons", reasons).
+			Str("bus", dev.Bus).
+			Str("address", dev.Address).
+			Msg("USB device looks like a KVM")
+	}
+	return findings
+}
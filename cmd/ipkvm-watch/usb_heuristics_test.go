@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestScoreUSBDeviceMediaKeyboard(t *testing.T) {
+	// boot keyboard plus consumer control and vendor defined interfaces
+	dev := USBDeviceRecord{
+		VendorID:     "046d",
+		ProductID:    "c31c",
+		Manufacturer: "Logitech",
+		Serial:       "",
+		Configurations: []USBConfiguration{{Value: 1, Interfaces: []USBInterface{
+			{Number: 0, Class: usbClassHID, SubClass: 1, Protocol: usbHIDProtoKeyboard},
+			{Number: 1, Class: usbClassHID, SubClass: 0, Protocol: 0},
+			{Number: 2, Class: usbClassHID, SubClass: 0, Protocol: 0},
+		}}},
+	}
+	score, reasons := scoreUSBDevice(dev)
+	if score >= heuristicMinScore {
+		t.Errorf("media keyboard scored %d: %v", score, reasons)
+	}
+	for _, reason := range reasons {
+		if reason == "HID mouse interface" {
+			t.Errorf("consumer control counted as a pointer: %v", reasons)
+		}
+	}
+}
+
+func TestScoreUSBDeviceJetKVM(t *testing.T) {
+	devices := parseLsusb(readFixture(t, "../../_device_output/jetkvm_lsusb"))
+	for _, dev := range devices {
+		if dev.VendorID != "1d6b" || dev.ProductID != "0104" {
+			continue
+		}
+		score, reasons := scoreUSBDevice(dev)
+		if heuristicConfidence(score) != "high" {
+			t.Errorf("jetkvm gadget scored %d: %v", score, reasons)
+		}
+		return
+	}
+	t.Fatal("no 1d6b:0104 device in the fixture")
+}
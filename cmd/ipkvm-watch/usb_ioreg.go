@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ioregNode is one "+-o" entry of `ioreg -p IOUSB -l -w 0` output
+type ioregNode struct {
+	Name       string
+	Location   string
+	Class      string
+	Properties map[string]string
+	Children   []*ioregNode
+	depth      int
+}
+
+// ioregNodeLine matches node lines
+// ex: "    +-o Composite KVM Device@00100000  <class IOUSBHostDevice, id 0x1000893fc, ...>"
+var ioregNodeLine = regexp.MustCompile(`^([ |]*)\+-o (.+?)(?:@([0-9a-fA-F]+))?  <class ([^,>]+)`)
+
+// ioregPropertyLine matches property lines inside a node's {} block
+// ex: `          "idVendor" = 7531`
+var ioregPropertyLine = regexp.MustCompile(`^[ |]*"([^"]+)" = (.*)$`)
+
+// parseIoreg builds the IOUSB plane tree. The returned nodes are the top
+// level entries, every device hangs off of them through Children.
+func parseIoreg(ioregOutput string) []*ioregNode {
+	roots := []*ioregNode{}
+	stack := []*ioregNode{}
+	var current *ioregNode
+	for _, line := range strings.Split(ioregOutput, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := ioregNodeLine.FindStringSubmatch(line); m != nil {
+			node := &ioregNode{
+				Name:       m[2],
+				Location:   m[3],
+				Class:      m[4],
+				Properties: map[string]string{},
+				depth:      len(m[1]),
+			}
+			// pop until the top of the stack is this node's parent
+			for len(stack) > 0 && stack[len(stack)-1].depth >= node.depth {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				roots = append(roots, node)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+			current = node
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := ioregPropertyLine.FindStringSubmatch(line); m != nil {
+			current.Properties[m[1]] = unquoteIoregValue(m[2])
+		}
+	}
+	return roots
+}
+
+// unquoteIoregValue strips the quotes from string values, numbers, data
+// (<...>) and dictionaries are left as they are
+func unquoteIoregValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// ioregUSBDevices flattens the tree into a record for every node that has
+// a vendor and product id
+func ioregUSBDevices(roots []*ioregNode) []USBDeviceRecord {
+	devices := []USBDeviceRecord{}
+	var walk func(nodes []*ioregNode)
+	walk = func(nodes []*ioregNode) {
+		for _, node := range nodes {
+			if dev, ok := node.usbDevice(); ok {
+				devices = append(devices, dev)
+			}
+			walk(node.Children)
+		}
+	}
+	walk(roots)
+	return devices
+}
+
+// usbDevice converts a node to a record. ioreg reports ids as decimal
+// integers so they are converted back to the hex form used everywhere else.
+func (n *ioregNode) usbDevice() (USBDeviceRecord, bool) {
+	vid, err := strconv.ParseInt(n.Properties["idVendor"], 10, 64)
+	if err != nil {
+		return USBDeviceRecord{}, false
+	}
+	pid, err := strconv.ParseInt(n.Properties["idProduct"], 10, 64)
+	if err != nil {
+		return USBDeviceRecord{}, false
+	}
+	dev := USBDeviceRecord{
+		Address:      formatSysfsNumber(n.Properties["USB Address"]),
+		VendorID:     fmt.Sprintf("%04x", vid),
+		ProductID:    fmt.Sprintf("%04x", pid),
+		Manufacturer: firstIoregProperty(n.Properties, "kUSBVendorString", "USB Vendor Name"),
+		Product:      firstIoregProperty(n.Properties, "kUSBProductString", "USB Product Name"),
+		Serial:       firstIoregProperty(n.Properties, "USB Serial Number", "kUSBSerialNumberString"),
+		Signature:    strings.Trim(n.Properties["UsbDeviceSignature"], "<>"),
+	}
+	if class, err := strconv.Atoi(n.Properties["bDeviceClass"]); err == nil {
+		dev.Class = class
+	}
+	if bcd, err := strconv.ParseInt(n.Properties["bcdDevice"], 10, 64); err == nil {
+		dev.BCDDevice = fmt.Sprintf("%x.%02x", bcd>>8, bcd&0xff)
+	}
+	// the top byte of the locationID is the controller counted from 0,
+	// buses are counted from 1 like they are on linux
+	if location, err := strconv.ParseInt(n.Properties["locationID"], 10, 64); err == nil {
+		dev.Location = fmt.Sprintf("0x%08x", location)
+		dev.Bus = fmt.Sprintf("%03d", location>>24+1)
+	}
+	// ioreg doesn't list interfaces in the IOUSB plane but the signature
+	// carries their class triples
+	if interfaces, ok := parseUsbDeviceSignature(dev.Signature, dev.Serial); ok {
+		dev.Configurations = []USBConfiguration{{Value: 1, Interfaces: interfaces}}
+	}
+	return dev, true
+}
+
+func firstIoregProperty(properties map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := properties[key]; ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseUsbDeviceSignature decodes the interface classes from a
+// UsbDeviceSignature. The layout is vid, pid and bcdDevice (little endian),
+// the serial number string, the device class/subclass/protocol and then a
+// class/subclass/protocol triple per interface.
+// ex: 6b1d 0401 0001 "CAFEBABE" 000000 e00103 0a0000 030101 030000 080650
+func parseUsbDeviceSignature(signature string, serial string) ([]USBInterface, bool) {
+	b, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+	offset := 6 + len(serial) + 3
+	if len(b) < offset || (len(b)-offset)%3 != 0 {
+		return nil, false
+	}
+	interfaces := []USBInterface{}
+	for i := offset; i+2 < len(b); i += 3 {
+		interfaces = append(interfaces, USBInterface{
+			Number:   len(interfaces),
+			Class:    int(b[i]),
+			SubClass: int(b[i+1]),
+			Protocol: int(b[i+2]),
+		})
+	}
+	return interfaces, true
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestParseIoregGLKVM(t *testing.T) {
+	roots := parseIoreg(readFixture(t, "../../_device_output/mac_glkvm"))
+	if len(roots) != 1 || roots[0].Name != "Composite KVM Device" || roots[0].Location != "00100000" {
+		t.Fatalf("got roots %+v", roots)
+	}
+	devices := ioregUSBDevices(roots)
+	if len(devices) != 1 {
+		t.Fatalf("got %d devices, want 1", len(devices))
+	}
+	dev := devices[0]
+	if dev.Bus != "001" || dev.Address != "001" || dev.Location != "0x00100000" {
+		t.Errorf("got bus %q address %q location %q", dev.Bus, dev.Address, dev.Location)
+	}
+	if dev.VendorID != "1d6b" || dev.ProductID != "0104" || dev.BCDDevice != "1.00" {
+		t.Errorf("got %s:%s bcdDevice %s", dev.VendorID, dev.ProductID, dev.BCDDevice)
+	}
+	if dev.Manufacturer != "GLKVM" || dev.Product != "Composite KVM Device" || dev.Serial != "CAFEBABE" {
+		t.Errorf("got manufacturer %q product %q serial %q", dev.Manufacturer, dev.Product, dev.Serial)
+	}
+	if len(dev.Configurations) != 1 {
+		t.Fatalf("got %d configurations, want 1", len(dev.Configurations))
+	}
+	want := [][3]int{{0xe0, 0x01, 0x03}, {0x0a, 0, 0}, {0x03, 0x01, 0x01}, {0x03, 0, 0}, {0x08, 0x06, 0x50}}
+	interfaces := dev.Configurations[0].Interfaces
+	if len(interfaces) != len(want) {
+		t.Fatalf("got %d interfaces, want %d", len(interfaces), len(want))
+	}
+	for i, iface := range interfaces {
+		if got := [3]int{iface.Class, iface.SubClass, iface.Protocol}; got != want[i] {
+			t.Errorf("interface %d got %02x, want %02x", i, got, want[i])
+		}
+	}
+}
+
+func TestParseIoregNested(t *testing.T) {
+	out := `+-o Root  <class IORegistryEntry, id 0x100000100, retain 10>
+  +-o AppleUSBXHCI@01000000  <class AppleUSBXHCI, id 0x100000200, registered>
+    | {
+    |   "locationID" = 16777216
+    | }
+    +-o Hub@01100000  <class IOUSBHostDevice, id 0x100000300, registered>
+    | | {
+    | |   "idVendor" = 1452
+    | |   "idProduct" = 4357
+    | |   "locationID" = 17825792
+    | |   "bDeviceClass" = 9
+    | | }
+    | +-o Keyboard@01110000  <class IOUSBHostDevice, id 0x100000400, registered>
+    |     {
+    |       "idVendor" = 1452
+    |       "idProduct" = 592
+    |       "locationID" = 17891328
+    |       "USB Address" = 3
+    |     }
+    +-o Mouse@01200000  <class IOUSBHostDevice, id 0x100000500, registered>
+        {
+          "idVendor" = 1133
+          "idProduct" = 49271
+          "locationID" = 18874368
+        }
+`
+	roots := parseIoreg(out)
+	if len(roots) != 1 {
+		t.Fatalf("got %d roots, want 1", len(roots))
+	}
+	controller := roots[0].Children[0]
+	if len(controller.Children) != 2 || len(controller.Children[0].Children) != 1 {
+		t.Fatalf("got tree %+v", controller)
+	}
+	devices := ioregUSBDevices(roots)
+	if len(devices) != 3 {
+		t.Fatalf("got %d devices, want 3", len(devices))
+	}
+	keyboard := devices[1]
+	if keyboard.VendorID != "05ac" || keyboard.ProductID != "0250" || keyboard.Bus != "002" || keyboard.Address != "003" {
+		t.Errorf("got keyboard %+v", keyboard)
+	}
+}
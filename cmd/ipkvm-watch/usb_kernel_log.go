@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// kernelLogFiles are read when no log files are given and the journal
+// isn't available
+var kernelLogFiles = []string{"/var/log/kern.log.1", "/var/log/kern.log"}
+
+// KernelUSBEvent is one connection of a usb device rebuilt from kernel log lines
+type KernelUSBEvent struct {
+	Port         string // ex: 1-1.2
+	DeviceNumber string
+	VendorID     string
+	ProductID    string
+	BCDDevice    string
+	Manufacturer string
+	Product      string
+	Serial       string
+	Time         time.Time
+	Uptime       float64 // seconds since boot when there is no wall clock time
+	Boot         int     // boots counted from 1 in log order, uptimes only compare within one
+}
+
+var (
+	// "[ 1234.567890] " dmesg and kern.log uptime prefix
+	klogUptime = regexp.MustCompile(`^\[\s*(\d+\.\d+)\]\s*`)
+	// "[Thu Oct 16 10:00:00 2025] " dmesg -T prefix
+	klogCtime = regexp.MustCompile(`^\[(\w{3} \w{3}\s+\d+ \d{2}:\d{2}:\d{2} \d{4})\]\s*`)
+	// "2025-10-16T10:00:00.123456+00:00 host kernel: " rsyslog and journalctl -o short-iso
+	klogISO = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2}))\s+\S+\s+kernel:\s*`)
+	// "Oct 16 10:00:00 host kernel: " classic syslog
+	klogSyslog = regexp.MustCompile(`^(\w{3}\s+\d+ \d{2}:\d{2}:\d{2})\s+\S+\s+kernel:\s*`)
+	// "usb 1-1: <message>"
+	klogUSB = regexp.MustCompile(`^usb (\d+-[\d.]+): (.*)$`)
+
+	klogNewDevice = regexp.MustCompile(`^New USB device found, idVendor=([0-9a-fA-F]{4}), idProduct=([0-9a-fA-F]{4})(?:, bcdDevice=\s*([0-9a-fA-F.]+))?`)
+	klogNumber    = regexp.MustCompile(`^new .*USB device number (\d+)`)
+
+	// "-- Boot 1a2b3c... --" separator journalctl prints between boots
+	klogBootSeparator = regexp.MustCompile(`^-- Boot [0-9a-f]+ --$`)
+)
+
+// klogLine is a kernel message and when it was logged
+type klogLine struct {
+	Message string
+	Time    time.Time
+	Uptime  float64
+	Boot    int
+}
+
+// parseKernelLogLines strips the timestamp prefixes of dmesg, dmesg -T,
+// syslog and journalctl short-iso lines. `journalctl -o export` entries
+// are read from their MESSAGE, __REALTIME_TIMESTAMP and _BOOT_ID fields.
+// Every line gets the boot it was logged in, a new boot starts at a
+// journal boot id or separator, at the kernel's version banner and when
+// the uptime goes backwards.
+func parseKernelLogLines(text string, now time.Time) []klogLine {
+	lines := []klogLine{}
+	var export klogLine
+	boot, bootID, lastUptime := 1, "", 0.0
+	newBoot := func() {
+		if len(lines) > 0 {
+			boot++
+		}
+		lastUptime = 0
+	}
+	add := func(l klogLine) {
+		if strings.HasPrefix(l.Message, "Linux version ") || l.Uptime != 0 && l.Uptime < lastUptime {
+			newBoot()
+		}
+		if l.Uptime != 0 {
+			lastUptime = l.Uptime
+		}
+		l.Boot = boot
+		lines = append(lines, l)
+	}
+	for _, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+
+		// journal export format
+		if id, ok := strings.CutPrefix(raw, "_BOOT_ID="); ok {
+			if bootID != "" && id != bootID {
+				newBoot()
+			}
+			bootID = id
+			continue
+		}
+		if strings.HasPrefix(raw, "__REALTIME_TIMESTAMP=") {
+			usec, err := strconv.ParseInt(strings.TrimPrefix(raw, "__REALTIME_TIMESTAMP="), 10, 64)
+			if err == nil {
+				export.Time = time.UnixMicro(usec)
+			}
+			continue
+		}
+		if strings.HasPrefix(raw, "MESSAGE=") {
+			export.Message = strings.TrimPrefix(raw, "MESSAGE=")
+			continue
+		}
+		if raw == "" {
+			if export.Message != "" {
+				add(export)
+			}
+			export = klogLine{}
+			continue
+		}
+		if klogBootSeparator.MatchString(raw) {
+			newBoot()
+			continue
+		}
+
+		l := klogLine{}
+		if m := klogISO.FindStringSubmatch(raw); m != nil {
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999-0700"} {
+				if t, err := time.Parse(layout, m[1]); err == nil {
+					l.Time = t
+					break
+				}
+			}
+			raw = raw[len(m[0]):]
+		} else if m := klogSyslog.FindStringSubmatch(raw); m != nil {
+			// syslog doesn't log the year, assume the last year that isn't in the future
+			t, err := time.ParseInLocation("Jan _2 15:04:05 2006", fmt.Sprintf("%s %d", m[1], now.Year()), now.Location())
+			if err == nil {
+				if t.After(now) {
+					t = t.AddDate(-1, 0, 0)
+				}
+				l.Time = t
+			}
+			raw = raw[len(m[0]):]
+		} else if m := klogCtime.FindStringSubmatch(raw); m != nil {
+			t, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", m[1], now.Location())
+			if err == nil {
+				l.Time = t
+			}
+			raw = raw[len(m[0]):]
+		}
+		if m := klogUptime.FindStringSubmatch(raw); m != nil {
+			l.Uptime, _ = strconv.ParseFloat(m[1], 64)
+			raw = raw[len(m[0]):]
+		}
+		l.Message = raw
+		add(l)
+	}
+	if export.Message != "" {
+		add(export)
+	}
+	return lines
+}
+
+// parseKernelUSBEvents rebuilds usb connections from kernel messages. Each
+// "New USB device found" starts a new connection on that port and the
+// Product/Manufacturer/SerialNumber lines that follow fill it in.
+func parseKernelUSBEvents(lines []klogLine) []KernelUSBEvent {
+	events := []KernelUSBEvent{}
+	current := map[string]int{}    // port -> index into events
+	numbers := map[string]string{} // port -> last device number
+	for _, l := range lines {
+		m := klogUSB.FindStringSubmatch(l.Message)
+		if m == nil {
+			continue
+		}
+		port, msg := m[1], m[2]
+		if n := klogNumber.FindStringSubmatch(msg); n != nil {
+			numbers[port] = n[1]
+			continue
+		}
+		if n := klogNewDevice.FindStringSubmatch(msg); n != nil {
+			events = append(events, KernelUSBEvent{
+				Port:         port,
+				DeviceNumber: numbers[port],
+				VendorID:     strings.ToLower(n[1]),
+				ProductID:    strings.ToLower(n[2]),
+				BCDDevice:    n[3],
+				Time:         l.Time,
+				Uptime:       l.Uptime,
+				Boot:         l.Boot,
+			})
+			current[port] = len(events) - 1
+			continue
+		}
+		i, ok := current[port]
+		if !ok {
+			continue
+		}
+		if v, ok := strings.CutPrefix(msg, "Product: "); ok {
+			events[i].Product = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(msg, "Manufacturer: "); ok {
+			events[i].Manufacturer = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(msg, "SerialNumber: "); ok {
+			events[i].Serial = strings.TrimSpace(v)
+		} else if strings.HasPrefix(msg, "USB disconnect") {
+			delete(current, port)
+		}
+	}
+	return events
+}
+
+// when formats an event time for output
+func (ev KernelUSBEvent) when() string {
+	if !ev.Time.IsZero() {
+		return ev.Time.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("uptime %.6fs in boot %d", ev.Uptime, ev.Boot)
+}
+
+// before orders two connections by wall clock time when both have one.
+// Uptimes restart at every boot so otherwise the boots are compared first
+// and the uptimes only within one.
+func (ev KernelUSBEvent) before(other KernelUSBEvent) bool {
+	if !ev.Time.IsZero() && !other.Time.IsZero() {
+		return ev.Time.Before(other.Time)
+	}
+	if ev.Boot != other.Boot {
+		return ev.Boot < other.Boot
+	}
+	return ev.Uptime < other.Uptime
+}
+
+// matchKernelUSBEvents runs the usb indicators against past connections.
+// Repeated connections of the same device are folded into one finding with
+// the first and last time it was seen.
+func matchKernelUSBEvents(usbIndicators map[string][]USBDevice, events []KernelUSBEvent) []USBFinding {
+	type seen struct {
+		finding     USBFinding
+		first, last KernelUSBEvent
+	}
+	order := []string{}
+	byKey := map[string]*seen{}
+	for _, ev := range events {
+		dev := USBDeviceRecord{
+			Bus:          formatSysfsNumber(strings.SplitN(ev.Port, "-", 2)[0]),
+			Address:      formatSysfsNumber(ev.DeviceNumber),
+			VendorID:     ev.VendorID,
+			ProductID:    ev.ProductID,
+			BCDDevice:    ev.BCDDevice,
+			Manufacturer: ev.Manufacturer,
+			Product:      ev.Product,
+			Serial:       ev.Serial,
+		}
+		for vendor, indicators := range usbIndicators {
+			for _, indicator := range indicators {
+				f, ok := matchUSBDevice(vendor, indicator, dev)
+				if !ok {
+					continue
+				}
+				key := strings.Join([]string{vendor, indicator.Manufacturer, indicator.VID, indicator.PID,
+					ev.VendorID, ev.ProductID, ev.Manufacturer, ev.Product, ev.Serial}, "|")
+				s, ok := byKey[key]
+				if !ok {
+					s = &seen{finding: f, first: ev, last: ev}
+					byKey[key] = s
+					order = append(order, key)
+				}
+				if ev.before(s.first) {
+					s.first = ev
+				}
+				if s.last.before(ev) {
+					s.last = ev
+					s.finding.Bus = f.Bus
+					s.finding.Address = f.Address
+				}
+			}
+		}
+	}
+
+	findings := []USBFinding{}
+	for _, key := range order {
+		s := byKey[key]
+		f := s.finding
+		f.Historical = true
+		f.Source = "kernel log"
+		f.FirstSeen = s.first.when()
+		f.LastSeen = s.last.when()
+		findings = append(findings, f)
+		log.Info().
+			Str("vendor", f.Vendor).
+			Str("confidence", f.Confidence).
+			Str("first_seen", f.FirstSeen).
+			Str("last_seen", f.LastSeen).
+			Strs("matched", f.MatchedFields).
+			Msg("Matched USB device in kernel log history")
+	}
+	return findings
+}
+
+// readKernelLogs returns the kernel log text from the given files, or on
+// linux from the journal, kern.log or dmesg (in that order)
+func readKernelLogs(paths []string) string {
+	if len(paths) > 0 {
+		text := []string{}
+		for _, path := range paths {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Error().Err(err).Str("path", path).Msg("Failed to read kernel log")
+				continue
+			}
+			text = append(text, string(b))
+		}
+		return strings.Join(text, "\n")
+	}
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	out, err := exec.Command("journalctl", "_TRANSPORT=kernel", "-o", "short-iso", "--no-pager").Output()
+	if err == nil && len(out) > 0 {
+		return string(out)
+	}
+	log.Debug().Err(err).Msg("journalctl unavailable, trying kern.log")
+	text := []string{}
+	for _, path := range kernelLogFiles {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			text = append(text, string(b))
+		}
+	}
+	if len(text) > 0 {
+		return strings.Join(text, "\n")
+	}
+	out, err = exec.Command("dmesg").Output()
+	if err != nil {
+		log.Error().Err(err).Msg("subprocess to read the kernel log failed")
+	}
+	return string(out)
+}
+
+func checkKernelUSBHistory(usbIndicators map[string][]USBDevice, paths []string) []USBFinding {
+	text := readKernelLogs(paths)
+	if text == "" {
+		return []USBFinding{}
+	}
+	events := parseKernelUSBEvents(parseKernelLogLines(text, time.Now()))
+	log.Debug().Int("count", len(events)).Msg("Rebuilt USB connections from kernel log")
+	return matchKernelUSBEvents(usbIndicators, events)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKernelUSBHistoryAcrossBoots(t *testing.T) {
+	lines := parseKernelLogLines(readFixture(t, "testdata/dmesg_two_boots"), time.Now())
+	events := parseKernelUSBEvents(lines)
+	// the root hub (usb1) isn't a port, the second boot is only seen from
+	// the uptime going backwards
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].Boot != 1 || events[1].Boot != 2 || events[1].DeviceNumber != "2" {
+		t.Errorf("got events %+v", events)
+	}
+
+	indicators := map[string][]USBDevice{"glkvm": {{Manufacturer: "GLKVM"}}}
+	findings := matchKernelUSBEvents(indicators, events)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+	// the second boot's uptime is lower but it's the later connection
+	f := findings[0]
+	if f.FirstSeen != "uptime 512.268771s in boot 1" || f.LastSeen != "uptime 20.482519s in boot 2" {
+		t.Errorf("got first %q last %q", f.FirstSeen, f.LastSeen)
+	}
+	if f.Address != "002" {
+		t.Errorf("got address %q from the last connection, want 002", f.Address)
+	}
+}
+
+func TestKernelUSBHistoryJournalExport(t *testing.T) {
+	export := `__REALTIME_TIMESTAMP=1760608800000000
+_BOOT_ID=aaaa
+MESSAGE=usb 1-1: New USB device found, idVendor=1d6b, idProduct=0104, bcdDevice= 1.00
+_TRANSPORT=kernel
+
+__REALTIME_TIMESTAMP=1760608800000100
+_BOOT_ID=aaaa
+MESSAGE=usb 1-1: Manufacturer: GLKVM
+
+__REALTIME_TIMESTAMP=1760695200000000
+_BOOT_ID=bbbb
+MESSAGE=usb 1-1: New USB device found, idVendor=1d6b, idProduct=0104, bcdDevice= 1.00
+
+__REALTIME_TIMESTAMP=1760695200000100
+_BOOT_ID=bbbb
+MESSAGE=usb 1-1: Manufacturer: GLKVM
+`
+	events := parseKernelUSBEvents(parseKernelLogLines(export, time.Now()))
+	if len(events) != 2 || events[0].Boot != 1 || events[1].Boot != 2 {
+		t.Fatalf("got events %+v", events)
+	}
+	findings := matchKernelUSBEvents(map[string][]USBDevice{"glkvm": {{Manufacturer: "GLKVM"}}}, events)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+	first := time.UnixMicro(1760608800000000).Format(time.RFC3339)
+	last := time.UnixMicro(1760695200000000).Format(time.RFC3339)
+	if findings[0].FirstSeen != first || findings[0].LastSeen != last {
+		t.Errorf("got first %q last %q, want %q %q", findings[0].FirstSeen, findings[0].LastSeen, first, last)
+	}
+}
+
+func TestKernelUSBEventBefore(t *testing.T) {
+	wall := time.Date(2025, 10, 16, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		a, b KernelUSBEvent
+		want bool
+	}{
+		{"wall clock wins over boots", KernelUSBEvent{Time: wall, Boot: 2}, KernelUSBEvent{Time: wall.Add(time.Hour), Boot: 1}, true},
+		{"earlier boot", KernelUSBEvent{Uptime: 500, Boot: 1}, KernelUSBEvent{Uptime: 20, Boot: 2}, true},
+		{"later boot", KernelUSBEvent{Uptime: 20, Boot: 2}, KernelUSBEvent{Uptime: 500, Boot: 1}, false},
+		{"same boot", KernelUSBEvent{Uptime: 20, Boot: 1}, KernelUSBEvent{Uptime: 500, Boot: 1}, true},
+		{"one wall clock, same boot", KernelUSBEvent{Time: wall, Uptime: 20, Boot: 1}, KernelUSBEvent{Uptime: 500, Boot: 1}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.before(tt.b); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
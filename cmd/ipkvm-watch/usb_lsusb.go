@@ -0,0 +1,172 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lsusbBusLine matches the first line of each device in `lsusb -v` output
+// ex: "Bus 007 Device 003: ID 1d6b:0104 Linux Foundation Multifunction Composite Gadget"
+var lsusbBusLine = regexp.MustCompile(`^Bus (\d+) Device (\d+): ID ([0-9a-fA-F]{4}):([0-9a-fA-F]{4})`)
+
+// parseLsusb turns the output of `lsusb -v` into one record per device.
+// Only the descriptors we match on are kept, everything else (HID, CDC,
+// qualifier and status sections) is skipped.
+func parseLsusb(lsusbOutput string) []USBDeviceRecord {
+	devices := []USBDeviceRecord{}
+	var dev *USBDeviceRecord
+	var config *USBConfiguration
+	var iface *USBInterface
+	var endpoint *USBEndpoint
+	// section is the descriptor the current key/value lines belong to
+	section := ""
+
+	for _, line := range strings.Split(lsusbOutput, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := lsusbBusLine.FindStringSubmatch(line); m != nil {
+			devices = append(devices, USBDeviceRecord{
+				Bus:       m[1],
+				Address:   m[2],
+				VendorID:  strings.ToLower(m[3]),
+				ProductID: strings.ToLower(m[4]),
+			})
+			dev = &devices[len(devices)-1]
+			config, iface, endpoint = nil, nil, nil
+			section = ""
+			continue
+		}
+		if dev == nil {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		// section headers are lines like "Interface Descriptor:"
+		if strings.HasSuffix(trimmed, ":") && !strings.Contains(trimmed, "  ") {
+			switch trimmed {
+			case "Device Descriptor:":
+				section = "device"
+			case "Configuration Descriptor:":
+				dev.Configurations = append(dev.Configurations, USBConfiguration{})
+				config = &dev.Configurations[len(dev.Configurations)-1]
+				iface, endpoint = nil, nil
+				section = "configuration"
+			case "Interface Descriptor:":
+				if config == nil {
+					section = ""
+					continue
+				}
+				config.Interfaces = append(config.Interfaces, USBInterface{})
+				iface = &config.Interfaces[len(config.Interfaces)-1]
+				endpoint = nil
+				section = "interface"
+			case "Endpoint Descriptor:":
+				if iface == nil {
+					section = ""
+					continue
+				}
+				iface.Endpoints = append(iface.Endpoints, USBEndpoint{})
+				endpoint = &iface.Endpoints[len(iface.Endpoints)-1]
+				section = "endpoint"
+			default:
+				section = ""
+			}
+			continue
+		}
+
+		key, value := splitLsusbField(trimmed)
+		switch section {
+		case "device":
+			switch key {
+			case "idVendor":
+				dev.VendorName = stripLsusbNumber(value)
+			case "idProduct":
+				dev.ProductName = stripLsusbNumber(value)
+			case "bDeviceClass":
+				dev.Class = parseLsusbInt(value)
+			case "bcdDevice":
+				dev.BCDDevice = value
+			case "iManufacturer":
+				dev.Manufacturer = stripLsusbNumber(value)
+			case "iProduct":
+				dev.Product = stripLsusbNumber(value)
+			case "iSerial":
+				dev.Serial = stripLsusbNumber(value)
+			}
+		case "configuration":
+			switch key {
+			case "bConfigurationValue":
+				config.Value = parseLsusbInt(value)
+			case "iConfiguration":
+				config.Name = stripLsusbNumber(value)
+			}
+		case "interface":
+			switch key {
+			case "bInterfaceNumber":
+				iface.Number = parseLsusbInt(value)
+			case "bAlternateSetting":
+				iface.AlternateSetting = parseLsusbInt(value)
+			case "bInterfaceClass":
+				iface.Class = parseLsusbInt(value)
+			case "bInterfaceSubClass":
+				iface.SubClass = parseLsusbInt(value)
+			case "bInterfaceProtocol":
+				iface.Protocol = parseLsusbInt(value)
+			case "iInterface":
+				iface.Name = stripLsusbNumber(value)
+			}
+		case "endpoint":
+			switch {
+			case key == "bEndpointAddress":
+				// ex: "0x81  EP 1 IN"
+				fields := strings.Fields(value)
+				if len(fields) > 0 {
+					endpoint.Address = fields[0]
+				}
+				if len(fields) > 1 {
+					endpoint.Direction = fields[len(fields)-1]
+				}
+			case key == "wMaxPacketSize":
+				// ex: "0x0200  1x 512 bytes"
+				fields := strings.Fields(value)
+				if len(fields) > 0 {
+					size, err := strconv.ParseInt(fields[0], 0, 32)
+					if err == nil {
+						endpoint.MaxPacketSize = int(size) & 0x7ff
+					}
+				}
+			case strings.HasPrefix(trimmed, "Transfer Type"):
+				endpoint.TransferType = strings.TrimSpace(strings.TrimPrefix(trimmed, "Transfer Type"))
+			}
+		}
+	}
+	return devices
+}
+
+// splitLsusbField splits "iManufacturer           1 JetKVM" into its key and value
+func splitLsusbField(line string) (string, string) {
+	key, value, _ := strings.Cut(line, " ")
+	return key, strings.TrimSpace(value)
+}
+
+// stripLsusbNumber removes the leading descriptor index or id from a value
+// ex: "1 JetKVM" -> "JetKVM", "0x1d6b Linux Foundation" -> "Linux Foundation"
+func stripLsusbNumber(value string) string {
+	_, rest, _ := strings.Cut(value, " ")
+	return strings.TrimSpace(rest)
+}
+
+// parseLsusbInt parses the leading number of a value like "3 Human Interface Device"
+func parseLsusbInt(value string) int {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	i, err := strconv.ParseInt(fields[0], 0, 32)
+	if err != nil {
+		return 0
+	}
+	return int(i)
+}
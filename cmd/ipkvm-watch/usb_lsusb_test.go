@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestParseLsusb(t *testing.T) {
+	tests := []struct {
+		path         string
+		bus, address string
+		manufacturer string
+		product      string
+		serial       string
+		interfaces   []string // class:subclass:protocol
+	}{
+		{"../../_device_output/comet_lsusb", "001", "003", "GLKVM", "Composite KVM Device", "CAFEBABE",
+			[]string{"224:1:3", "10:0:0", "3:1:1", "3:0:0", "8:6:80", "8:6:80"}},
+		{"../../_device_output/jetkvm_lsusb", "007", "003", "JetKVM", "USB Emulation Device", "",
+			[]string{"3:1:1", "3:0:2", "3:1:2", "8:6:80"}},
+	}
+	for _, tt := range tests {
+		devices := parseLsusb(readFixture(t, tt.path))
+		if len(devices) != 1 {
+			t.Fatalf("%s: got %d devices, want 1", tt.path, len(devices))
+		}
+		dev := devices[0]
+		if dev.Bus != tt.bus || dev.Address != tt.address || dev.VendorID != "1d6b" || dev.ProductID != "0104" {
+			t.Errorf("%s: got bus %s address %s id %s:%s", tt.path, dev.Bus, dev.Address, dev.VendorID, dev.ProductID)
+		}
+		if dev.VendorName != "Linux Foundation" || dev.ProductName != "Multifunction Composite Gadget" {
+			t.Errorf("%s: got names %q %q", tt.path, dev.VendorName, dev.ProductName)
+		}
+		if dev.Manufacturer != tt.manufacturer || dev.Product != tt.product || dev.Serial != tt.serial {
+			t.Errorf("%s: got manufacturer %q product %q serial %q", tt.path, dev.Manufacturer, dev.Product, dev.Serial)
+		}
+		if dev.BCDDevice != "1.00" || dev.Class != 0 {
+			t.Errorf("%s: got bcdDevice %q class %d", tt.path, dev.BCDDevice, dev.Class)
+		}
+		if len(dev.Configurations) != 1 || dev.Configurations[0].Value != 1 {
+			t.Fatalf("%s: got configurations %+v", tt.path, dev.Configurations)
+		}
+		interfaces := []string{}
+		for i, intf := range dev.Configurations[0].Interfaces {
+			if intf.Number != i || intf.AlternateSetting != 0 {
+				t.Errorf("%s: interface %d is numbered %d alt %d", tt.path, i, intf.Number, intf.AlternateSetting)
+			}
+			interfaces = append(interfaces, fmt.Sprintf("%d:%d:%d", intf.Class, intf.SubClass, intf.Protocol))
+		}
+		if !slices.Equal(interfaces, tt.interfaces) {
+			t.Errorf("%s: got interfaces %v, want %v", tt.path, interfaces, tt.interfaces)
+		}
+	}
+}
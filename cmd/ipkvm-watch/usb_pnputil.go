@@ -0,0 +1,384 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pnpEntry is one device block from `pnputil /enum-devices` or from
+// `Get-CimInstance Win32_PnPEntity | Format-List`
+type pnpEntry struct {
+	InstanceID   string
+	Description  string
+	Name         string
+	Manufacturer string
+	Class        string
+	CompatibleID string
+	// Parent is the instance id of the parent device when the output
+	// lists it (pnputil /relations, DEVPKEY_Device_Parent)
+	Parent string
+	// ParentIDPrefix is the id prefix windows gives the children of the
+	// instance, ex: 9&9f4824e&0 for USB\VID_1D6B&PID_0104&MI_04\9&9f4824e&0&0004
+	ParentIDPrefix string
+}
+
+// pnpFieldLine matches "Key:   value" (pnputil) and "Key   : value" (Format-List)
+var pnpFieldLine = regexp.MustCompile(`^(\S[^:]*?)\s*:\s?(.*)$`)
+
+// pnpUSBInstance matches USB and HID instance ids
+// ex: USB\VID_1D6B&PID_0104&MI_04\9&9f4824e&0&0004
+var pnpUSBInstance = regexp.MustCompile(`(?i)^(USB|HID)\\VID_([0-9A-F]{4})&PID_([0-9A-F]{4})(?:&MI_([0-9A-F]{2}))?[^\\]*\\(.*)$`)
+
+// pnpStorInstance matches USB mass storage instance ids
+// ex: USBSTOR\CDROM&VEN_GLINET&PROD_FLASH_DRIVE&REV_1.00\A&24467633&0&CAFEBABE&0
+var pnpStorInstance = regexp.MustCompile(`(?i)^USBSTOR\\([^&\\]+)&VEN_([^&\\]*)&PROD_([^&\\]*)[^\\]*\\(.*)$`)
+
+// pnpCompatibleClass pulls the interface class triple out of a compatible id
+// ex: USB\Class_03&SubClass_01&Prot_01
+var pnpCompatibleClass = regexp.MustCompile(`(?i)Class_([0-9A-F]{2})(?:&SubClass_([0-9A-F]{2}))?(?:&Prot_([0-9A-F]{2}))?`)
+
+// parsePnPEntries splits the text into blocks and reads the fields we care
+// about. Blocks without an instance id (headers, other WMI classes) are
+// dropped and blocks that show up in both formats are merged.
+func parsePnPEntries(pnpOutput string) []pnpEntry {
+	entries := []pnpEntry{}
+	index := map[string]int{}
+	fields := map[string]string{}
+	lastKey := ""
+
+	flush := func() {
+		e := pnpEntry{
+			InstanceID:   firstPnPField(fields, "Instance ID", "PNPDeviceID", "DeviceID"),
+			Description:  firstPnPField(fields, "Device Description", "Description"),
+			Name:         firstPnPField(fields, "Name", "Caption", "Device Description"),
+			Manufacturer: firstPnPField(fields, "Manufacturer Name", "Manufacturer"),
+			Class:        firstPnPField(fields, "PNPClass", "Class Name"),
+			CompatibleID: fields["CompatibleID"],
+			Parent:       firstPnPField(fields, "Parent"),
+		}
+		fields = map[string]string{}
+		lastKey = ""
+		if e.InstanceID == "" {
+			return
+		}
+		key := strings.ToUpper(e.InstanceID)
+		if i, ok := index[key]; ok {
+			entries[i] = mergePnPEntries(entries[i], e)
+			return
+		}
+		index[key] = len(entries)
+		entries = append(entries, e)
+	}
+
+	for _, line := range strings.Split(pnpOutput, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "=====") {
+			flush()
+			continue
+		}
+		// Format-List wraps long values onto indented lines
+		if strings.HasPrefix(line, " ") && lastKey != "" {
+			fields[lastKey] += strings.TrimSpace(line)
+			continue
+		}
+		m := pnpFieldLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		lastKey = m[1]
+		fields[lastKey] = strings.TrimSpace(m[2])
+	}
+	flush()
+	return entries
+}
+
+func firstPnPField(fields map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := fields[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// mergePnPEntries fills the empty fields of a with the ones from b
+func mergePnPEntries(a pnpEntry, b pnpEntry) pnpEntry {
+	if a.Description == "" {
+		a.Description = b.Description
+	}
+	if a.Name == "" {
+		a.Name = b.Name
+	}
+	if a.Manufacturer == "" {
+		a.Manufacturer = b.Manufacturer
+	}
+	if a.Class == "" {
+		a.Class = b.Class
+	}
+	if a.CompatibleID == "" {
+		a.CompatibleID = b.CompatibleID
+	}
+	if a.Parent == "" {
+		a.Parent = b.Parent
+	}
+	if a.ParentIDPrefix == "" {
+		a.ParentIDPrefix = b.ParentIDPrefix
+	}
+	return a
+}
+
+// parseWindowsUSB groups PnP entries into one record per physical device.
+// Interface children (MI_xx) and HID children are attached to their parent
+// instance and USBSTOR disks to the parent whose serial is part of their
+// instance id.
+func parseWindowsUSB(pnpOutput string) []USBDeviceRecord {
+	return groupWindowsUSB(parsePnPEntries(pnpOutput))
+}
+
+// groupWindowsUSB does the grouping for parseWindowsUSB, it's shared with
+// the offline registry and setupapi parsers
+func groupWindowsUSB(entries []pnpEntry) []USBDeviceRecord {
+	devices := []USBDeviceRecord{}
+	// owner maps instance ids and child id prefixes to the device index
+	owner := map[string]int{}
+
+	// parents first so children always have somewhere to go
+	for _, e := range entries {
+		m := pnpUSBInstance.FindStringSubmatch(e.InstanceID)
+		if m == nil || m[4] != "" || !strings.EqualFold(m[1], "USB") {
+			continue
+		}
+		dev := USBDeviceRecord{
+			VendorID:     strings.ToLower(m[2]),
+			ProductID:    strings.ToLower(m[3]),
+			Manufacturer: e.Manufacturer,
+			Product:      e.Description,
+			InstanceID:   e.InstanceID,
+			Instances:    []string{e.InstanceID},
+		}
+		// windows makes up an instance id with & in it when there's no serial
+		if !strings.Contains(m[5], "&") {
+			dev.Serial = m[5]
+		}
+		devices = append(devices, dev)
+		setWindowsOwner(owner, len(devices)-1, e.InstanceID, e.ParentIDPrefix)
+	}
+
+	// USB interfaces before HID and USBSTOR children, those hang off an
+	// interface rather than the device
+	children := []pnpEntry{}
+	for _, usbPass := range []bool{true, false} {
+		for _, e := range entries {
+			if strings.HasPrefix(strings.ToUpper(e.InstanceID), `USB\`) == usbPass {
+				children = append(children, e)
+			}
+		}
+	}
+	for _, e := range children {
+		if m := pnpUSBInstance.FindStringSubmatch(e.InstanceID); m != nil {
+			usb := strings.EqualFold(m[1], "USB")
+			if m[4] == "" && usb {
+				continue
+			}
+			prefix := windowsIDPrefix(m[5])
+			i := findWindowsParent(&devices, owner, e.Parent, prefix, strings.ToLower(m[2]), strings.ToLower(m[3]), usb)
+			number := -1
+			if m[4] != "" {
+				n, err := strconv.ParseInt(m[4], 16, 32)
+				if err == nil {
+					number = int(n)
+				}
+			}
+			devices[i].Instances = append(devices[i].Instances, e.InstanceID)
+			addWindowsInterface(&devices[i], number, e)
+			setWindowsOwner(owner, i, e.InstanceID, e.ParentIDPrefix)
+			if usb {
+				setWindowsOwner(owner, i, prefix)
+			}
+		} else if m := pnpStorInstance.FindStringSubmatch(e.InstanceID); m != nil {
+			var dev *USBDeviceRecord
+			if i, ok := owner[strings.ToUpper(e.Parent)]; ok && e.Parent != "" {
+				dev = &devices[i]
+			} else {
+				dev = findWindowsStorParent(devices, m[4])
+			}
+			if dev == nil {
+				devices = append(devices, USBDeviceRecord{
+					VendorName:  strings.Trim(m[2], "_"),
+					ProductName: strings.ReplaceAll(strings.Trim(m[3], "_"), "_", " "),
+					InstanceID:  e.InstanceID,
+				})
+				dev = &devices[len(devices)-1]
+			} else if dev.VendorName == "" {
+				dev.VendorName = strings.Trim(m[2], "_")
+				dev.ProductName = strings.ReplaceAll(strings.Trim(m[3], "_"), "_", " ")
+			}
+			dev.Instances = append(dev.Instances, e.InstanceID)
+			// the disk is the logical unit behind a mass storage interface,
+			// only add it when that interface wasn't listed
+			if !hasInterfaceClass(*dev, usbClassMassStorage) {
+				addWindowsInterface(dev, -1, e)
+			}
+		}
+	}
+	for i := range devices {
+		for j := range devices[i].Configurations {
+			interfaces := devices[i].Configurations[j].Interfaces
+			sort.Slice(interfaces, func(a, b int) bool { return interfaces[a].Number < interfaces[b].Number })
+		}
+	}
+	return devices
+}
+
+// windowsIDPrefix drops the last part of an instance suffix, what's left is
+// shared by every child of the same parent
+// ex: 9&9f4824e&0&0004 -> 9&9f4824e&0
+func windowsIDPrefix(suffix string) string {
+	i := strings.LastIndex(suffix, "&")
+	if i < 0 {
+		return ""
+	}
+	return suffix[:i]
+}
+
+func setWindowsOwner(owner map[string]int, i int, keys ...string) {
+	for _, key := range keys {
+		if key != "" {
+			owner[strings.ToUpper(key)] = i
+		}
+	}
+}
+
+func hasInterfaceClass(dev USBDeviceRecord, class int) bool {
+	for _, config := range dev.Configurations {
+		for _, iface := range config.Interfaces {
+			if iface.Class == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findWindowsParent returns the index of the device a child belongs to.
+// The parent instance id wins, then the id prefix the child shares with its
+// siblings. Without either the child goes to a device with the VID/PID,
+// USB interfaces only to one that has no other children yet so a second
+// gadget with the same VID/PID isn't merged into the first. A device is
+// created when only the child interfaces were listed.
+func findWindowsParent(devices *[]USBDeviceRecord, owner map[string]int, parent string, prefix string, vid string, pid string, usb bool) int {
+	if i, ok := owner[strings.ToUpper(parent)]; ok && parent != "" {
+		return i
+	}
+	if i, ok := owner[strings.ToUpper(prefix)]; ok && prefix != "" {
+		return i
+	}
+	for i := range *devices {
+		dev := (*devices)[i]
+		if dev.VendorID != vid || dev.ProductID != pid {
+			continue
+		}
+		if !usb || len(dev.Instances) <= 1 {
+			return i
+		}
+	}
+	*devices = append(*devices, USBDeviceRecord{VendorID: vid, ProductID: pid})
+	return len(*devices) - 1
+}
+
+// findWindowsStorParent matches a USBSTOR instance suffix
+// (ex: A&24467633&0&CAFEBABE&0) to the device with that serial
+func findWindowsStorParent(devices []USBDeviceRecord, suffix string) *USBDeviceRecord {
+	parts := strings.Split(suffix, "&")
+	for i := range devices {
+		if devices[i].Serial == "" {
+			continue
+		}
+		for _, part := range parts {
+			if strings.EqualFold(part, devices[i].Serial) {
+				return &devices[i]
+			}
+		}
+	}
+	return nil
+}
+
+// windowsInstanceOf checks if the instance id is the device itself or one
+// of the interface, HID or USBSTOR children groupWindowsUSB put under it
+func windowsInstanceOf(instanceID string, dev USBDeviceRecord) bool {
+	if strings.EqualFold(instanceID, dev.InstanceID) {
+		return true
+	}
+	for _, id := range dev.Instances {
+		if strings.EqualFold(instanceID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// addWindowsInterface adds or refines interface number on dev. Entries
+// without an interface number (USBSTOR, single interface HID) get a new one.
+func addWindowsInterface(dev *USBDeviceRecord, number int, e pnpEntry) {
+	if len(dev.Configurations) == 0 {
+		dev.Configurations = []USBConfiguration{{Value: 1}}
+	}
+	config := &dev.Configurations[0]
+	class, subClass, protocol := windowsInterfaceClass(e)
+	var iface *USBInterface
+	if number >= 0 {
+		for i := range config.Interfaces {
+			if config.Interfaces[i].Number == number {
+				iface = &config.Interfaces[i]
+				break
+			}
+		}
+	} else {
+		number = len(config.Interfaces)
+	}
+	if iface == nil {
+		config.Interfaces = append(config.Interfaces, USBInterface{Number: number})
+		iface = &config.Interfaces[len(config.Interfaces)-1]
+	}
+	if iface.Class == 0 {
+		iface.Class = class
+	}
+	if iface.SubClass == 0 {
+		iface.SubClass = subClass
+	}
+	if iface.Protocol == 0 {
+		iface.Protocol = protocol
+	}
+	// prefer the more specific HID/disk names over "USB Input Device"
+	if iface.Name == "" || strings.HasPrefix(strings.ToUpper(e.InstanceID), "HID\\") || strings.HasPrefix(strings.ToUpper(e.InstanceID), "USBSTOR\\") {
+		iface.Name = e.Name
+	}
+}
+
+// windowsInterfaceClass works out the class triple from the compatible ids
+// when Win32_PnPEntity lists them, otherwise from the description
+func windowsInterfaceClass(e pnpEntry) (int, int, int) {
+	if m := pnpCompatibleClass.FindStringSubmatch(e.CompatibleID); m != nil {
+		class, _ := strconv.ParseInt(m[1], 16, 32)
+		subClass, _ := strconv.ParseInt(m[2], 16, 32)
+		protocol, _ := strconv.ParseInt(m[3], 16, 32)
+		return int(class), int(subClass), int(protocol)
+	}
+	text := strings.ToLower(e.Description + " " + e.Name + " " + e.Class)
+	id := strings.ToUpper(e.InstanceID)
+	switch {
+	case strings.HasPrefix(id, "USBSTOR\\") || strings.Contains(text, "mass storage") || strings.Contains(text, "cd-rom"):
+		return usbClassMassStorage, 6, 80
+	case strings.Contains(text, "keyboard"):
+		return usbClassHID, 1, usbHIDProtoKeyboard
+	case strings.Contains(text, "mouse"):
+		return usbClassHID, 0, usbHIDProtoMouse
+	case strings.Contains(text, "input device") || strings.Contains(text, "hid"):
+		return usbClassHID, 0, 0
+	case strings.Contains(text, "rndis") || strings.Contains(text, "ethernet") || strings.Contains(text, "ncm"):
+		return usbClassCDC, 0, 0
+	}
+	return 0, 0, 0
+}
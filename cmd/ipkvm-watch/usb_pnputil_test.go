@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func readFixture(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseWindowsUSBComet(t *testing.T) {
+	devices := parseWindowsUSB(readFixture(t, "../../_device_output/comet_windows"))
+	if len(devices) != 1 {
+		t.Fatalf("got %d devices, want 1", len(devices))
+	}
+	dev := devices[0]
+	if dev.VendorID != "1d6b" || dev.ProductID != "0104" || dev.Serial != "CAFEBABE" {
+		t.Errorf("got %s:%s serial %q", dev.VendorID, dev.ProductID, dev.Serial)
+	}
+	if dev.VendorName != "GLINET" || dev.ProductName != "FLASH DRIVE" {
+		t.Errorf("got USBSTOR vendor %q product %q", dev.VendorName, dev.ProductName)
+	}
+	if len(dev.Instances) != 4 {
+		t.Errorf("got %d instances, want 4: %v", len(dev.Instances), dev.Instances)
+	}
+}
+
+func TestParseWindowsUSBSharedVIDPID(t *testing.T) {
+	devices := parseWindowsUSB(readFixture(t, "testdata/pnputil_two_gadgets"))
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+	want := map[string][]string{
+		`USB\VID_1D6B&PID_0104\CAFEBABE`: {
+			`USB\VID_1D6B&PID_0104\CAFEBABE`,
+			`USB\VID_1D6B&PID_0104&MI_00\9&9f4824e&0&0000`,
+			`USB\VID_1D6B&PID_0104&MI_04\9&9f4824e&0&0004`,
+			`USBSTOR\CDROM&VEN_GLINET&PROD_FLASH_DRIVE&REV_1.00\A&24467633&0&CAFEBABE&0`,
+		},
+		`USB\VID_1D6B&PID_0104\6&2d3e4f5&0&3`: {
+			`USB\VID_1D6B&PID_0104\6&2d3e4f5&0&3`,
+			`USB\VID_1D6B&PID_0104&MI_00\7&1a2b3c4&0&0000`,
+			`USB\VID_1D6B&PID_0104&MI_01\7&1a2b3c4&0&0001`,
+			`HID\VID_1D6B&PID_0104&MI_00\8&3f4e5d6&0&0000`,
+		},
+	}
+	for _, dev := range devices {
+		instances, ok := want[dev.InstanceID]
+		if !ok {
+			t.Errorf("unexpected device %q", dev.InstanceID)
+			continue
+		}
+		got := map[string]bool{}
+		for _, id := range dev.Instances {
+			got[id] = true
+		}
+		if len(got) != len(instances) {
+			t.Errorf("%s: got instances %v, want %v", dev.InstanceID, dev.Instances, instances)
+		}
+		for _, id := range instances {
+			if !got[id] {
+				t.Errorf("%s: missing child %s", dev.InstanceID, id)
+			}
+		}
+	}
+}
+
+func TestGroupWindowsUSBPrefixFallback(t *testing.T) {
+	// no parent ids, the children only share an id prefix with their siblings
+	devices := groupWindowsUSB([]pnpEntry{
+		{InstanceID: `USB\VID_1D6B&PID_0104\AAAA`},
+		{InstanceID: `USB\VID_1D6B&PID_0104\BBBB`},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&1111&0&0000`},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&2222&0&0000`},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_01\9&1111&0&0001`},
+	})
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+	if n := len(devices[0].Instances); n != 3 {
+		t.Errorf("first gadget has %d instances, want 3: %v", n, devices[0].Instances)
+	}
+	if n := len(devices[1].Instances); n != 2 {
+		t.Errorf("second gadget has %d instances, want 2: %v", n, devices[1].Instances)
+	}
+}
+
+func TestMatchUSBDeviceVIDPIDConfidence(t *testing.T) {
+	indicator := USBDevice{VID: "1d6b", PID: "0104"}
+	dev := USBDeviceRecord{VendorID: "1d6b", ProductID: "0104"}
+	if f, ok := matchUSBDevice("Generic", indicator, dev); !ok || f.Confidence != "low" {
+		t.Errorf("got %v %q, want low", ok, f.Confidence)
+	}
+	dev.InstanceID = `USB\VID_1D6B&PID_0104\CAFEBABE`
+	if f, ok := matchUSBDevice("Generic", indicator, dev); !ok || f.Confidence != "medium" {
+		t.Errorf("got %v %q for a windows device, want medium", ok, f.Confidence)
+	}
+	indicator.Confidence = "low"
+	if f, _ := matchUSBDevice("Generic", indicator, dev); f.Confidence != "low" {
+		t.Errorf("got %q, want the rule's low", f.Confidence)
+	}
+}
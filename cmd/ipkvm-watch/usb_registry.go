@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// registry device property keys under <instance>\Properties
+const (
+	// DEVPKEY_Device_BusReportedDeviceDesc, the product string the device reports
+	devpkeyDeviceDesc = `{540b947e-8b40-45bc-a8a2-6a0b894cbda2}\0004`
+	// DEVPKEY_Device_InstallDate, FirstInstallDate, LastArrivalDate and LastRemovalDate
+	devpkeyDeviceTimes = `{83da6326-97a6-4088-9453-a1923f573b29}`
+)
+
+// RegistryUSBDevice is one instance key under Enum\USB or Enum\USBSTOR of
+// a SYSTEM hive
+type RegistryUSBDevice struct {
+	InstanceID   string
+	VID          string
+	PID          string
+	Serial       string
+	FriendlyName string
+	DeviceDesc   string
+	BusReported  string
+	Manufacturer string
+	Service      string
+	LastWrite    string
+	FirstInstall string
+	LastArrival  string
+	LastRemoval  string
+
+	compatibleID   string
+	parentIDPrefix string
+	times          []time.Time
+}
+
+// registryString strips the inf reference windows puts in front of
+// localised strings, ex: "@input.inf,%hid_device_system_keyboard%;HID Keyboard Device"
+func registryString(s string) string {
+	if strings.HasPrefix(s, "@") {
+		if i := strings.LastIndex(s, ";"); i >= 0 {
+			return s[i+1:]
+		}
+	}
+	return s
+}
+
+func formatHistoryTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// currentControlSet resolves CurrentControlSet, which only exists on a live
+// system, through Select\Current
+func currentControlSet(root *RegistryKey) (*RegistryKey, error) {
+	name := "ControlSet001"
+	if sel, err := root.Subkey("Select"); err == nil {
+		if v, ok := sel.Value("Current"); ok {
+			if n, ok := v.Uint32(); ok {
+				name = fmt.Sprintf("ControlSet%03d", n)
+			}
+		}
+	}
+	return root.Subkey(name)
+}
+
+// readRegistryUSBDevices lists every device that was ever enumerated under
+// Enum\USB and Enum\USBSTOR of the current control set
+func readRegistryUSBDevices(hive *RegistryHive) ([]RegistryUSBDevice, error) {
+	root, err := hive.Root()
+	if err != nil {
+		return nil, err
+	}
+	controlSet, err := currentControlSet(root)
+	if err != nil {
+		return nil, err
+	}
+	devices := []RegistryUSBDevice{}
+	for _, bus := range []string{"USB", "USBSTOR"} {
+		enum, err := controlSet.OpenPath(`Enum\` + bus)
+		if err != nil {
+			log.Debug().Err(err).Str("key", bus).Msg("Enum key not in hive")
+			continue
+		}
+		deviceKeys, err := enum.Subkeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, deviceKey := range deviceKeys {
+			instances, err := deviceKey.Subkeys()
+			if err != nil {
+				log.Error().Err(err).Str("key", deviceKey.Name).Msg("Failed to read device instances")
+				continue
+			}
+			for _, instance := range instances {
+				devices = append(devices, readRegistryUSBInstance(bus, deviceKey.Name, instance))
+			}
+		}
+	}
+	return devices, nil
+}
+
+func readRegistryUSBInstance(bus string, deviceName string, instance *RegistryKey) RegistryUSBDevice {
+	dev := RegistryUSBDevice{
+		InstanceID: bus + `\` + deviceName + `\` + instance.Name,
+		LastWrite:  formatHistoryTime(instance.LastWrite),
+		times:      []time.Time{instance.LastWrite},
+	}
+	if m := pnpUSBInstance.FindStringSubmatch(dev.InstanceID); m != nil {
+		dev.VID = strings.ToLower(m[2])
+		dev.PID = strings.ToLower(m[3])
+		// windows makes up an instance id with & in it when there's no serial
+		if !strings.Contains(m[5], "&") {
+			dev.Serial = m[5]
+		}
+	} else if m := pnpStorInstance.FindStringSubmatch(dev.InstanceID); m != nil {
+		// ex: CAFEBABE&0, the trailing number is the logical unit
+		serial, _, _ := strings.Cut(m[4], "&")
+		if strings.Count(m[4], "&") == 1 {
+			dev.Serial = serial
+		}
+	}
+	if v, ok := instance.Value("FriendlyName"); ok {
+		dev.FriendlyName = registryString(v.String())
+	}
+	if v, ok := instance.Value("DeviceDesc"); ok {
+		dev.DeviceDesc = registryString(v.String())
+	}
+	if v, ok := instance.Value("Mfg"); ok {
+		dev.Manufacturer = registryString(v.String())
+	}
+	if v, ok := instance.Value("Service"); ok {
+		dev.Service = v.String()
+	}
+	if v, ok := instance.Value("CompatibleIDs"); ok {
+		dev.compatibleID = v.String()
+	}
+	// only written by older windows, newer ones keep it out of the hive and
+	// the children are grouped by their shared id prefix instead
+	if v, ok := instance.Value("ParentIdPrefix"); ok {
+		dev.parentIDPrefix = v.String()
+	}
+
+	// the properties key is only readable by SYSTEM on a live machine but
+	// it's there in a collected hive
+	if key, err := instance.OpenPath(`Properties\` + devpkeyDeviceDesc); err == nil {
+		if v, ok := key.Value(""); ok {
+			dev.BusReported = v.String()
+		}
+	}
+	if key, err := instance.OpenPath(`Properties\` + devpkeyDeviceTimes); err == nil {
+		for id, field := range map[string]*string{"0065": &dev.FirstInstall, "0066": &dev.LastArrival, "0067": &dev.LastRemoval} {
+			sub, err := key.Subkey(id)
+			if err != nil {
+				continue
+			}
+			if v, ok := sub.Value(""); ok {
+				if t, ok := v.Time(); ok && !t.IsZero() {
+					*field = formatHistoryTime(t)
+					dev.times = append(dev.times, t)
+				}
+			}
+		}
+	}
+	return dev
+}
+
+// seen returns the first and last time anything was recorded for the device
+func (d RegistryUSBDevice) seen() (time.Time, time.Time) {
+	var first, last time.Time
+	for _, t := range d.times {
+		if t.IsZero() {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// registryUSBRecords groups the instances into device records the same way
+// the live pnputil output is grouped
+func registryUSBRecords(devices []RegistryUSBDevice) []USBDeviceRecord {
+	entries := []pnpEntry{}
+	for _, d := range devices {
+		name := d.FriendlyName
+		if name == "" {
+			name = d.DeviceDesc
+		}
+		description := d.BusReported
+		if description == "" {
+			description = d.DeviceDesc
+		}
+		entries = append(entries, pnpEntry{
+			InstanceID:     d.InstanceID,
+			Description:    description,
+			Name:           name,
+			Manufacturer:   d.Manufacturer,
+			CompatibleID:   d.compatibleID,
+			ParentIDPrefix: d.parentIDPrefix,
+		})
+	}
+	return groupWindowsUSB(entries)
+}
+
+// matchRegistryUSBDevices runs the usb indicators against the devices from
+// the hive. The finding times come from every instance key that belongs to
+// the matched device.
+func matchRegistryUSBDevices(usbIndicators map[string][]USBDevice, devices []RegistryUSBDevice) []USBFinding {
+	findings := []USBFinding{}
+	for _, rec := range registryUSBRecords(devices) {
+		var first, last time.Time
+		for _, d := range devices {
+			if !windowsInstanceOf(d.InstanceID, rec) {
+				continue
+			}
+			f, l := d.seen()
+			if !f.IsZero() && (first.IsZero() || f.Before(first)) {
+				first = f
+			}
+			if l.After(last) {
+				last = l
+			}
+		}
+		for _, f := range matchUSBDevices(usbIndicators, []USBDeviceRecord{rec}) {
+			f.Historical = true
+			f.Source = "registry"
+			f.FirstSeen = formatHistoryTime(first)
+			f.LastSeen = formatHistoryTime(last)
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// checkRegistryHive is the offline mode, it reads a SYSTEM hive collected
+// from a windows machine
+func checkRegistryHive(usbIndicators map[string][]USBDevice, path string) ([]RegistryUSBDevice, []USBFinding, error) {
+	hive, err := openRegistryHive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	devices, err := readRegistryUSBDevices(hive)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range devices {
+		log.Debug().
+			Str("instance", d.InstanceID).
+			Str("name", d.FriendlyName).
+			Str("last_write", d.LastWrite).
+			Msg("USB device in registry")
+	}
+	return devices, matchRegistryUSBDevices(usbIndicators, devices), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchRegistryUSBDevicesSharedVIDPID(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 10, d, 12, 0, 0, 0, time.UTC) }
+	devices := []RegistryUSBDevice{
+		{InstanceID: `USB\VID_1D6B&PID_0104\AAAA`, times: []time.Time{day(1)}},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&1111&0&0000`, times: []time.Time{day(2)}},
+		{InstanceID: `USB\VID_1D6B&PID_0104\BBBB`, times: []time.Time{day(10)}},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&2222&0&0000`, times: []time.Time{day(20)}},
+	}
+	indicators := map[string][]USBDevice{"Generic": {{VID: "1d6b", PID: "0104"}}}
+	findings := matchRegistryUSBDevices(indicators, devices)
+	if len(findings) != 2 {
+		t.Fatalf("got %d findings, want 2", len(findings))
+	}
+	want := [][2]string{
+		{formatHistoryTime(day(1)), formatHistoryTime(day(2))},
+		{formatHistoryTime(day(10)), formatHistoryTime(day(20))},
+	}
+	for i, f := range findings {
+		if f.FirstSeen != want[i][0] || f.LastSeen != want[i][1] {
+			t.Errorf("finding %d seen %s - %s, want %s - %s", i, f.FirstSeen, f.LastSeen, want[i][0], want[i][1])
+		}
+	}
+}
+
+func TestRegistryParentIDPrefix(t *testing.T) {
+	// children listed before the second parent, only the prefix ties them
+	devices := []RegistryUSBDevice{
+		{InstanceID: `USB\VID_1D6B&PID_0104\AAAA`, parentIDPrefix: "9&1111&0"},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&2222&0&0000`},
+		{InstanceID: `USB\VID_1D6B&PID_0104&MI_00\9&1111&0&0000`},
+		{InstanceID: `USB\VID_1D6B&PID_0104\BBBB`, parentIDPrefix: "9&2222&0"},
+	}
+	records := registryUSBRecords(devices)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if !windowsInstanceOf(`USB\VID_1D6B&PID_0104&MI_00\9&2222&0&0000`, records[1]) {
+		t.Errorf("BBBB is missing its interface: %v", records[1].Instances)
+	}
+	if windowsInstanceOf(`USB\VID_1D6B&PID_0104&MI_00\9&2222&0&0000`, records[0]) {
+		t.Errorf("AAAA got the interface of BBBB: %v", records[0].Instances)
+	}
+}
+
+func TestReadRegistryUSBDevicesHive(t *testing.T) {
+	hive, err := openRegistryHive("testdata/registry/SYSTEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := readRegistryUSBDevices(hive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("got %d devices, want 3: %+v", len(devices), devices)
+	}
+	parent := devices[0]
+	if parent.InstanceID != `USB\VID_1D6B&PID_0104\CAFEBABE` || parent.VID != "1d6b" || parent.PID != "0104" || parent.Serial != "CAFEBABE" {
+		t.Errorf("got %s %s:%s serial %q", parent.InstanceID, parent.VID, parent.PID, parent.Serial)
+	}
+	// the DEVPKEY_Device_CompatibleIds property sits next to it and mustn't be read instead
+	if parent.BusReported != "Comet" {
+		t.Errorf("got bus reported description %q, want Comet", parent.BusReported)
+	}
+	if parent.DeviceDesc != "USB Composite Device" || parent.Manufacturer != "(Standard USB Host Controller)" || parent.Service != "usbccgp" {
+		t.Errorf("got description %q manufacturer %q service %q", parent.DeviceDesc, parent.Manufacturer, parent.Service)
+	}
+	if parent.parentIDPrefix != "7&1a2b3c4&0" {
+		t.Errorf("got ParentIdPrefix %q", parent.parentIDPrefix)
+	}
+	if parent.FirstInstall != "2025-10-01T08:00:00Z" || parent.LastArrival != "2025-10-03T09:14:58Z" || parent.LastRemoval != "2025-10-02T18:30:00Z" {
+		t.Errorf("got install %s arrival %s removal %s", parent.FirstInstall, parent.LastArrival, parent.LastRemoval)
+	}
+	if parent.LastWrite != "2025-10-03T09:15:00Z" {
+		t.Errorf("got last write %s", parent.LastWrite)
+	}
+	stor := devices[2]
+	if stor.FriendlyName != "GLINET FLASH DRIVE USB Device" || stor.Serial != "CAFEBABE" {
+		t.Errorf("got USBSTOR name %q serial %q", stor.FriendlyName, stor.Serial)
+	}
+
+	records := registryUSBRecords(devices)
+	if len(records) != 1 || records[0].Product != "Comet" || len(records[0].Instances) != 3 {
+		t.Errorf("got records %+v", records)
+	}
+
+	indicators := map[string][]USBDevice{"Comet": {{VID: "1d6b", PID: "0104"}}}
+	findings := matchRegistryUSBDevices(indicators, devices)
+	if len(findings) != 1 {
+		t.Fatalf("got %d findings, want 1", len(findings))
+	}
+	f := findings[0]
+	if f.FirstSeen != "2025-10-01T08:00:00Z" || f.LastSeen != "2025-10-03T09:15:00Z" || !f.Historical {
+		t.Errorf("got finding seen %s - %s historical %v", f.FirstSeen, f.LastSeen, f.Historical)
+	}
+}
+
+func TestParseRegistryHiveNotRegf(t *testing.T) {
+	if _, err := parseRegistryHive(make([]byte, regfBaseBlockSize)); err == nil {
+		t.Error("expected an error without a regf signature")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// setupAPILogGlob is where windows keeps the device install log, older logs
+// are rotated to setupapi.dev.YYYYMMDD_HHMMSS.log next to it
+var setupAPILogGlob = `C:\Windows\INF\setupapi.dev*.log`
+
+// SetupAPIInstall is one device install section of setupapi.dev.log
+type SetupAPIInstall struct {
+	InstanceID string
+	Trigger    string // ex: Hardware initiated
+	Start      time.Time
+	Status     string
+}
+
+var (
+	// ">>>  [Device Install (Hardware initiated) - USB\VID_1D6B&PID_0104\CAFEBABE]"
+	setupAPISection = regexp.MustCompile(`^>>>\s+\[(?:Setup online )?Device Install \(([^)]*)\) - (.+)\]\s*$`)
+	// ">>>  Section start 2025/10/16 10:00:00.123"
+	setupAPIStart = regexp.MustCompile(`^>>>\s+Section start (\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)`)
+	// "<<<  [Exit status: SUCCESS]"
+	setupAPIStatus = regexp.MustCompile(`^<<<\s+\[Exit status: ([^\]]+)\]`)
+)
+
+// parseSetupAPILog pulls the usb device install sections out of the log.
+// The times are in the local time of the machine that wrote the log, which
+// isn't recorded, so loc should be that machine's zone.
+func parseSetupAPILog(text string, loc *time.Location) []SetupAPIInstall {
+	installs := []SetupAPIInstall{}
+	current := -1
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := setupAPISection.FindStringSubmatch(line); m != nil {
+			current = -1
+			id := strings.TrimSpace(m[2])
+			if !pnpUSBInstance.MatchString(id) && !pnpStorInstance.MatchString(id) {
+				continue
+			}
+			installs = append(installs, SetupAPIInstall{InstanceID: id, Trigger: m[1]})
+			current = len(installs) - 1
+			continue
+		}
+		if current < 0 {
+			continue
+		}
+		if m := setupAPIStart.FindStringSubmatch(line); m != nil {
+			t, err := time.ParseInLocation("2006/01/02 15:04:05.999", m[1], loc)
+			if err == nil {
+				installs[current].Start = t
+			}
+		} else if m := setupAPIStatus.FindStringSubmatch(line); m != nil {
+			installs[current].Status = m[1]
+			current = -1
+		}
+	}
+	return installs
+}
+
+// matchSetupAPIInstalls groups the installs into devices the same way the
+// live pnputil output is grouped and runs the usb indicators against them
+func matchSetupAPIInstalls(usbIndicators map[string][]USBDevice, installs []SetupAPIInstall) []USBFinding {
+	entries := []pnpEntry{}
+	seen := map[string]bool{}
+	for _, install := range installs {
+		key := strings.ToUpper(install.InstanceID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		entries = append(entries, pnpEntry{InstanceID: install.InstanceID})
+	}
+
+	findings := []USBFinding{}
+	for _, dev := range groupWindowsUSB(entries) {
+		var first, last time.Time
+		for _, install := range installs {
+			if install.Start.IsZero() || !windowsInstanceOf(install.InstanceID, dev) {
+				continue
+			}
+			if first.IsZero() || install.Start.Before(first) {
+				first = install.Start
+			}
+			if install.Start.After(last) {
+				last = install.Start
+			}
+		}
+		for _, f := range matchUSBDevices(usbIndicators, []USBDeviceRecord{dev}) {
+			f.Historical = true
+			f.Source = "setupapi"
+			f.FirstSeen = formatHistoryTime(first)
+			f.LastSeen = formatHistoryTime(last)
+			findings = append(findings, f)
+			log.Info().
+				Str("vendor", f.Vendor).
+				Str("instance", dev.InstanceID).
+				Str("first_seen", f.FirstSeen).
+				Msg("Matched USB device in setupapi log")
+		}
+	}
+	return findings
+}
+
+// checkSetupAPIHistory reads the given setupapi.dev.log files, or the live
+// ones on windows
+func checkSetupAPIHistory(usbIndicators map[string][]USBDevice, paths []string) []USBFinding {
+	if len(paths) == 0 {
+		if runtime.GOOS != "windows" {
+			return []USBFinding{}
+		}
+		matches, err := filepath.Glob(setupAPILogGlob)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list setupapi logs")
+		}
+		paths = matches
+	}
+	installs := []SetupAPIInstall{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to read setupapi log")
+			continue
+		}
+		// copies saved through powershell redirection are UTF-16
+		text := string(b)
+		if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+			text = decodeUTF16(b[2:])
+		}
+		installs = append(installs, parseSetupAPILog(text, time.Local)...)
+	}
+	log.Debug().Int("count", len(installs)).Msg("Read USB installs from setupapi logs")
+	return matchSetupAPIInstalls(usbIndicators, installs)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchSetupAPIInstallsSharedVIDPID(t *testing.T) {
+	installs := parseSetupAPILog(readFixture(t, "testdata/setupapi.dev.log"), time.UTC)
+	if len(installs) != 5 {
+		t.Fatalf("got %d installs, want 5", len(installs))
+	}
+	if installs[0].Trigger != "Hardware initiated" || installs[0].Status != "SUCCESS" {
+		t.Errorf("got trigger %q status %q", installs[0].Trigger, installs[0].Status)
+	}
+
+	indicators := map[string][]USBDevice{"Generic": {{VID: "1d6b", PID: "0104"}}}
+	findings := matchSetupAPIInstalls(indicators, installs)
+	if len(findings) != 2 {
+		t.Fatalf("got %d findings, want 2", len(findings))
+	}
+	want := [][2]string{
+		{"2025-10-01T09:00:00Z", "2025-10-20T07:45:00Z"},
+		{"2025-10-12T14:30:00Z", "2025-10-12T14:30:02Z"},
+	}
+	for i, f := range findings {
+		if f.FirstSeen != want[i][0] || f.LastSeen != want[i][1] {
+			t.Errorf("finding %d seen %s - %s, want %s - %s", i, f.FirstSeen, f.LastSeen, want[i][0], want[i][1])
+		}
+		if !f.Historical || f.Source != "setupapi" {
+			t.Errorf("finding %d not marked as setupapi history", i)
+		}
+	}
+}
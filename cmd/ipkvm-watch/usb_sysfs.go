@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sysfsUSBRoot is where the kernel exposes USB devices and interfaces.
+// It is a variable so that a fake tree can be used instead.
+var sysfsUSBRoot = "/sys/bus/usb/devices"
+
+// readSysfsUSBDevices walks a /sys/bus/usb/devices style tree and builds a
+// record for every device. Device entries are named like "1-1.2" or "usb1",
+// interface entries like "1-1.2:1.0" and are attached to their device.
+func readSysfsUSBDevices(root string) ([]USBDeviceRecord, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sysfs usb devices: %w", err)
+	}
+	devices := []USBDeviceRecord{}
+	for _, entry := range entries {
+		name := entry.Name()
+		// interfaces are picked up when the parent device is read
+		if strings.Contains(name, ":") {
+			continue
+		}
+		dev, err := readSysfsUSBDevice(filepath.Join(root, name))
+		if err != nil {
+			log.Debug().Err(err).Str("device", name).Msg("Skipping sysfs USB entry")
+			continue
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// readSysfsUSBDevice reads a single device directory and its interfaces
+func readSysfsUSBDevice(devPath string) (USBDeviceRecord, error) {
+	vid := readSysfsAttr(devPath, "idVendor")
+	pid := readSysfsAttr(devPath, "idProduct")
+	if vid == "" || pid == "" {
+		return USBDeviceRecord{}, fmt.Errorf("no idVendor/idProduct in %s", devPath)
+	}
+	dev := USBDeviceRecord{
+		Bus:          formatSysfsNumber(readSysfsAttr(devPath, "busnum")),
+		Address:      formatSysfsNumber(readSysfsAttr(devPath, "devnum")),
+		VendorID:     strings.ToLower(vid),
+		ProductID:    strings.ToLower(pid),
+		Class:        parseSysfsHex(readSysfsAttr(devPath, "bDeviceClass")),
+		BCDDevice:    formatSysfsBCD(readSysfsAttr(devPath, "bcdDevice")),
+		Manufacturer: readSysfsAttr(devPath, "manufacturer"),
+		Product:      readSysfsAttr(devPath, "product"),
+		Serial:       readSysfsAttr(devPath, "serial"),
+	}
+
+	// sysfs only exposes the active configuration
+	config := USBConfiguration{
+		Value: parseSysfsInt(readSysfsAttr(devPath, "bConfigurationValue")),
+		Name:  readSysfsAttr(devPath, "configuration"),
+	}
+	entries, err := os.ReadDir(devPath)
+	if err != nil {
+		return dev, err
+	}
+	base := filepath.Base(devPath)
+	for _, entry := range entries {
+		// interface dirs are named <device>:<config>.<interface>
+		if !strings.HasPrefix(entry.Name(), base+":") {
+			continue
+		}
+		ifacePath := filepath.Join(devPath, entry.Name())
+		iface := USBInterface{
+			Number:           parseSysfsHex(readSysfsAttr(ifacePath, "bInterfaceNumber")),
+			AlternateSetting: parseSysfsHex(readSysfsAttr(ifacePath, "bAlternateSetting")),
+			Class:            parseSysfsHex(readSysfsAttr(ifacePath, "bInterfaceClass")),
+			SubClass:         parseSysfsHex(readSysfsAttr(ifacePath, "bInterfaceSubClass")),
+			Protocol:         parseSysfsHex(readSysfsAttr(ifacePath, "bInterfaceProtocol")),
+			Name:             readSysfsAttr(ifacePath, "interface"),
+			Endpoints:        readSysfsEndpoints(ifacePath),
+		}
+		config.Interfaces = append(config.Interfaces, iface)
+	}
+	sort.Slice(config.Interfaces, func(i, j int) bool {
+		return config.Interfaces[i].Number < config.Interfaces[j].Number
+	})
+	dev.Configurations = []USBConfiguration{config}
+	return dev, nil
+}
+
+// readSysfsEndpoints reads the ep_XX directories of an interface
+func readSysfsEndpoints(ifacePath string) []USBEndpoint {
+	endpoints := []USBEndpoint{}
+	entries, err := os.ReadDir(ifacePath)
+	if err != nil {
+		return endpoints
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "ep_") {
+			continue
+		}
+		epPath := filepath.Join(ifacePath, entry.Name())
+		endpoints = append(endpoints, USBEndpoint{
+			Address:       "0x" + readSysfsAttr(epPath, "bEndpointAddress"),
+			Direction:     strings.ToUpper(readSysfsAttr(epPath, "direction")),
+			TransferType:  readSysfsAttr(epPath, "type"),
+			MaxPacketSize: parseSysfsHex(readSysfsAttr(epPath, "wMaxPacketSize")) & 0x7ff,
+		})
+	}
+	return endpoints
+}
+
+// readSysfsAttr returns the trimmed contents of a sysfs attribute or "" if
+// it doesn't exist (ex: devices without a serial)
+func readSysfsAttr(dir string, attr string) string {
+	b, err := os.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func parseSysfsHex(value string) int {
+	i, err := strconv.ParseInt(value, 16, 32)
+	if err != nil {
+		return 0
+	}
+	return int(i)
+}
+
+func parseSysfsInt(value string) int {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// formatSysfsNumber pads bus and device numbers the same way lsusb does
+func formatSysfsNumber(value string) string {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("%03d", i)
+}
+
+// formatSysfsBCD turns a sysfs bcdDevice ("0100") into the lsusb form ("1.00")
+func formatSysfsBCD(value string) string {
+	if len(value) != 4 {
+		return value
+	}
+	major := strings.TrimLeft(value[:2], "0")
+	if major == "" {
+		major = "0"
+	}
+	return major + "." + value[2:]
+}
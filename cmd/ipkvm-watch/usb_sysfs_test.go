@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfsUSB copies testdata/sysfs into a temp dir. Colons aren't allowed
+// in module file names so the fixture spells them %3A. The interfaces are
+// linked at the top level like the kernel does.
+func fakeSysfsUSB(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	err := filepath.WalkDir("testdata/sysfs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel("testdata/sysfs", path)
+		if err != nil {
+			return err
+		}
+		rel, err = url.PathUnescape(rel)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, rel)
+		if d.IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if strings.Contains(d.Name(), "%3A") {
+				return os.Symlink(target, filepath.Join(root, filepath.Base(target)))
+			}
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestReadSysfsUSBDevices(t *testing.T) {
+	defer func(root string) { sysfsUSBRoot = root }(sysfsUSBRoot)
+	sysfsUSBRoot = fakeSysfsUSB(t)
+
+	devices, err := readSysfsUSBDevices(sysfsUSBRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+	var dev USBDeviceRecord
+	for _, d := range devices {
+		if d.ProductID == "0104" {
+			dev = d
+		}
+	}
+	if dev.Bus != "007" || dev.Address != "003" || dev.VendorID != "1d6b" {
+		t.Errorf("got bus %s address %s vid %s", dev.Bus, dev.Address, dev.VendorID)
+	}
+	if dev.Manufacturer != "JetKVM" || dev.Product != "USB Emulation Device" || dev.Serial != "" {
+		t.Errorf("got manufacturer %q product %q serial %q", dev.Manufacturer, dev.Product, dev.Serial)
+	}
+	if dev.BCDDevice != "1.00" {
+		t.Errorf("got bcdDevice %q", dev.BCDDevice)
+	}
+	if len(dev.Configurations) != 1 {
+		t.Fatalf("got %d configurations, want 1", len(dev.Configurations))
+	}
+	config := dev.Configurations[0]
+	if config.Value != 1 || config.Name != "Config 1: HID" {
+		t.Errorf("got configuration %d %q", config.Value, config.Name)
+	}
+	if len(config.Interfaces) != 4 {
+		t.Fatalf("got %d interfaces, want 4", len(config.Interfaces))
+	}
+	keyboard := config.Interfaces[0]
+	if keyboard.Class != usbClassHID || keyboard.SubClass != 1 || keyboard.Protocol != usbHIDProtoKeyboard {
+		t.Errorf("got interface 0 class %d:%d:%d", keyboard.Class, keyboard.SubClass, keyboard.Protocol)
+	}
+	if len(keyboard.Endpoints) != 2 || keyboard.Endpoints[1].Address != "0x81" || keyboard.Endpoints[1].Direction != "IN" {
+		t.Errorf("got interface 0 endpoints %+v", keyboard.Endpoints)
+	}
+	storage := config.Interfaces[3]
+	if storage.Class != usbClassMassStorage || storage.Endpoints[0].MaxPacketSize != 512 {
+		t.Errorf("got interface 3 %+v", storage)
+	}
+}
+
+func TestReadSysfsUSBDevicesMissingRoot(t *testing.T) {
+	if _, err := readSysfsUSBDevices(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing sysfs root")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// watchUSB listens for kernel uevents and checks every usb device as soon
+// as it is plugged in. It only returns on error.
+func watchUSB(usbIndicators map[string][]USBDevice) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+	defer unix.Close(fd)
+	// group 1 is the kernel's broadcast group, the port id is left to the
+	// kernel since another netlink socket in the process may hold our pid
+	addr := &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1, Pid: 0}
+	if err := unix.Bind(fd, addr); err != nil {
+		return fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+	log.Info().Msg("Watching for USB devices")
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == unix.EINTR || err == unix.ENOBUFS {
+				continue
+			}
+			return fmt.Errorf("failed to read uevent: %w", err)
+		}
+		ev, err := parseUevent(buf[:n])
+		if err != nil {
+			log.Debug().Err(err).Msg("Skipping uevent")
+			continue
+		}
+		if !ev.isUSBDeviceAdd() {
+			continue
+		}
+		// handle each device on its own so a slow settle doesn't hold up the socket
+		go func(ev Uevent) {
+			time.Sleep(ueventSettleTime)
+			handleUevent(ev, usbIndicators, "/sys")
+		}(ev)
+	}
+}
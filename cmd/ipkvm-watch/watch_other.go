@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// watchUSB needs kernel uevents which are linux only
+func watchUSB(usbIndicators map[string][]USBDevice) error {
+	return fmt.Errorf("watch mode is not supported on %s", runtime.GOOS)
+}